@@ -4,20 +4,45 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
+	"user-balance-service/internal/analytics"
+	analyticsdb "user-balance-service/internal/analytics/db"
 	cashaccount "user-balance-service/internal/cash_account"
 	"user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/closing"
+	closingdb "user-balance-service/internal/closing/db"
 	"user-balance-service/internal/config"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/internal/orders"
+	ordersdb "user-balance-service/internal/orders/db"
+	"user-balance-service/internal/outbox"
+	outboxdb "user-balance-service/internal/outbox/db"
+	"user-balance-service/internal/payment"
+	paymentdb "user-balance-service/internal/payment/db"
+	"user-balance-service/internal/payment/fake"
+	"user-balance-service/internal/payout"
+	payoutdb "user-balance-service/internal/payout/db"
+	"user-balance-service/internal/payout/simulator"
+	"user-balance-service/internal/promo"
+	promodb "user-balance-service/internal/promo/db"
+	"user-balance-service/internal/reportjob"
+	reportjobdb "user-balance-service/internal/reportjob/db"
+	"user-balance-service/internal/statement"
+	statementdb "user-balance-service/internal/statement/db"
+	"user-balance-service/internal/webhook"
+	webhookdb "user-balance-service/internal/webhook/db"
 	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/filestore"
 	"user-balance-service/pkg/logging"
-
-	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
 	logger := logging.NewLogger()
 
 	logger.Info("Craete router")
-	router := httprouter.New()
+	router := handlers.NewRouter()
 
 	cfg := config.GetConfig()
 
@@ -32,20 +57,218 @@ func main() {
 		panic(err)
 	}
 
+	bus := events.NewBus()
+
 	logger.Info("Creating storage")
 	storage := db.NewStorage(database, logger)
 
+	logger.Info("Create file store")
+	var store filestore.Store
+	switch cfg.FileStore.Type {
+	case "local":
+		store, err = filestore.NewLocal(cfg.FileStore.LocalDir)
+		if err != nil {
+			panic(err)
+		}
+	case "s3":
+		store, err = filestore.NewS3(filestore.S3Options{
+			Endpoint:  cfg.FileStore.S3Endpoint,
+			Region:    cfg.FileStore.S3Region,
+			Bucket:    cfg.FileStore.S3Bucket,
+			AccessKey: cfg.FileStore.S3AccessKey,
+			SecretKey: cfg.FileStore.S3SecretKey,
+			PathStyle: cfg.FileStore.S3PathStyle,
+			Timeout:   cfg.FileStore.S3Timeout,
+		})
+		if err != nil {
+			panic(err)
+		}
+	default:
+		panic(fmt.Errorf("Unknown file store %s", cfg.FileStore.Type))
+	}
+
 	logger.Info("Creating service")
-	service := cashaccount.NewService(storage, logger)
+	spendPriority := cashaccount.SpendPriority(cfg.Bonus.SpendPriority)
+	if !spendPriority.Valid() {
+		panic(fmt.Errorf("Unknown bonus spend priority %s", spendPriority))
+	}
+	csvSeparator := []rune(cfg.Reports.CsvSeparator)
+	if len(csvSeparator) != 1 {
+		panic(fmt.Errorf("CSV separator must be one character, got %q", cfg.Reports.CsvSeparator))
+	}
+	service := cashaccount.NewService(storage, logger, spendPriority, bus, csvSeparator[0], store)
+
+	admin, err := middleware.NewToken(cfg.Admin.Token)
+	if err != nil {
+		panic(err)
+	}
 
 	logger.Info("Register handler")
-	handler := cashaccount.NewHandler(service, logger)
+	broker := cashaccount.NewBroker()
+	bus.Subscribe(broker)
+	links, err := cashaccount.NewLinkSigner(cfg.Reports.PublicUrl, cfg.Reports.LinkSecret, cfg.Reports.LinkToken, cfg.Reports.LinkTTL, nil)
+	if err != nil {
+		panic(err)
+	}
+	handler := cashaccount.NewHandler(service, broker, links, logger)
 
 	handler.Register(router)
+
+	logger.Info("Register report job handler")
+	reportJobService := reportjob.NewService(reportjobdb.NewStorage(database, logger), service, cfg.Reports.Workers, cfg.Reports.JobLease, logger)
+	reportjob.NewHandler(reportJobService, links, logger).Register(router)
+	go reportJobService.Run(context.Background(), cfg.Reports.PollInterval)
+
+	if cfg.ReportSchedule.Enabled {
+		logger.Info("Start report scheduler")
+		hour, minute, err := reportjob.ParseScheduleTime(cfg.ReportSchedule.Time)
+		if err != nil {
+			panic(err)
+		}
+		location, err := time.LoadLocation(cfg.ReportSchedule.Timezone)
+		if err != nil {
+			panic(err)
+		}
+		notifiers := make([]reportjob.Notifier, 0)
+		if cfg.ReportSchedule.WebhookUrl != "" {
+			notifiers = append(notifiers, reportjob.NewWebhookNotifier(cfg.ReportSchedule.WebhookUrl, cfg.ReportSchedule.WebhookSecret, cfg.Webhook.Timeout))
+		}
+		if cfg.ReportSchedule.SmtpAddr != "" && len(cfg.ReportSchedule.EmailTo) > 0 {
+			notifiers = append(notifiers, reportjob.NewEmailNotifier(cfg.ReportSchedule.SmtpAddr, cfg.ReportSchedule.SmtpUsername,
+				cfg.ReportSchedule.SmtpPassword, cfg.ReportSchedule.EmailFrom, cfg.ReportSchedule.EmailTo))
+		}
+		scheduler, err := reportjob.NewScheduler(reportjobdb.NewScheduleStorage(database, logger), service, links, notifiers, reportjob.ScheduleOptions{
+			Day:         cfg.ReportSchedule.Day,
+			Hour:        hour,
+			Minute:      minute,
+			Location:    location,
+			Granularity: cashaccount.Granularity(cfg.ReportSchedule.Granularity),
+			Format:      cashaccount.ReportFormat(cfg.ReportSchedule.Format),
+		}, logger)
+		if err != nil {
+			panic(err)
+		}
+		go scheduler.Run(context.Background(), cfg.ReportSchedule.CheckInterval)
+	}
+
+	logger.Info("Register statement handler")
+	statementFormat := cashaccount.ExportFormat(cfg.Statements.Format)
+	if !statementFormat.Valid() {
+		panic(fmt.Errorf("Unknown statement format %s", statementFormat))
+	}
+	statementLocale, ok := cashaccount.ParseLocale(cfg.Statements.Locale)
+	if !ok {
+		panic(fmt.Errorf("Unknown statement locale %s", cfg.Statements.Locale))
+	}
+	statementService := statement.NewService(statementdb.NewStorage(database, logger), service, store, statementFormat, statementLocale, logger)
+	statement.NewHandler(statementService, logger).Register(router)
+	if cfg.Statements.Enabled {
+		go statementService.Run(context.Background(), cfg.Statements.Interval)
+	}
+
+	logger.Info("Register analytics handler")
+	analytics.NewHandler(analytics.NewService(analyticsdb.NewStorage(database, logger), logger), logger).Register(router)
+
+	logger.Info("Register period closing handler")
+	closingService := closing.NewService(closingdb.NewStorage(database, logger), service, logger)
+	closing.NewHandler(closingService, links, admin, logger).Register(router)
+
+	logger.Info("Register promo code handler")
+	promoStorage := promodb.NewStorage(database, logger)
+	promoService := promo.NewService(promoStorage, logger, bus)
+	promo.NewHandler(promoService, admin, logger).Register(router)
+
+	logger.Info("Register payment handler")
+	var provider payment.Provider
+	switch cfg.Payment.Provider {
+	case "fake":
+		logger.Warn("Fake payment provider is enabled, it must be used only for development and tests")
+		fakeProvider, err := fake.NewProvider(cfg.Payment.FakeBaseUrl, cfg.Payment.CallbackUrl, cfg.Payment.Secret, logger)
+		if err != nil {
+			panic(err)
+		}
+		fakeProvider.Register(router)
+		provider = fakeProvider
+	case "":
+		panic(fmt.Errorf("Payment provider is not set"))
+	default:
+		panic(fmt.Errorf("Unknown payment provider %s", cfg.Payment.Provider))
+	}
+	paymentStorage := paymentdb.NewStorage(database, logger)
+	paymentService := payment.NewService(paymentStorage, provider, logger, bus)
+	payment.NewHandler(paymentService, logger).Register(router)
+
+	logger.Info("Register payout handler")
+	var gateway payout.Gateway
+	switch cfg.Payout.Gateway {
+	case "simulator":
+		gateway = simulator.NewGateway(cfg.Payout.SimulatorDelay, cfg.Payout.SimulatorFailureRate)
+	default:
+		panic(fmt.Errorf("Unknown payout gateway %s", cfg.Payout.Gateway))
+	}
+	payoutStorage := payoutdb.NewStorage(database, logger)
+	payoutService := payout.NewService(payoutStorage, gateway, logger, bus)
+	payout.NewHandler(payoutService, logger).Register(router)
+	go payoutService.Run(context.Background(), cfg.Payout.PollInterval)
+
+	logger.Info("Register webhook handler")
+	webhookStorage := webhookdb.NewStorage(database, logger)
+	webhookService := webhook.NewService(webhookStorage, webhook.Options{
+		MaxAttempts:  cfg.Webhook.MaxAttempts,
+		BaseBackoff:  cfg.Webhook.BaseBackoff,
+		MaxBackoff:   cfg.Webhook.MaxBackoff,
+		PollInterval: cfg.Webhook.PollInterval,
+		Timeout:      cfg.Webhook.Timeout,
+		Concurrency:  cfg.Webhook.Concurrency,
+	}, logger)
+	webhook.NewHandler(webhookService, logger).Register(router)
+	go webhookService.Run(context.Background())
+
+	logger.Info("Start outbox relay")
+	var publisher outbox.Publisher
+	switch cfg.Outbox.Publisher {
+	case "memory":
+		publisher = outbox.NewMemoryPublisher()
+	case "file":
+		publisher, err = outbox.NewFilePublisher(cfg.Outbox.FilePath)
+		if err != nil {
+			panic(err)
+		}
+	case "kafka":
+		publisher = outbox.NewKafkaPublisher(cfg.Outbox.KafkaBrokers, cfg.Outbox.KafkaTopic)
+	default:
+		panic(fmt.Errorf("Unknown outbox publisher %s", cfg.Outbox.Publisher))
+	}
+	// webhooks are queued by the relay, so the deliveries of committed events survive a restart
+	publisher = outbox.NewMultiPublisher(publisher, webhookService)
+	defer publisher.Close()
+	relay := outbox.NewRelay(outboxdb.NewStorage(database, logger), publisher, cfg.Outbox.BatchSize, logger)
+	go relay.Run(context.Background(), cfg.Outbox.PollInterval)
+
+	if cfg.Orders.Enabled {
+		logger.Info("Start order commands consumer")
+		var consumer orders.Consumer
+		var replier orders.Replier
+		switch cfg.Orders.Broker {
+		case "memory":
+			queue := orders.NewMemoryQueue(100)
+			consumer, replier = queue, queue
+		case "kafka":
+			consumer = orders.NewKafkaConsumer(cfg.Orders.KafkaBrokers, cfg.Orders.CommandsTopic, cfg.Orders.GroupId)
+			replier = orders.NewKafkaReplier(cfg.Orders.KafkaBrokers, cfg.Orders.RepliesTopic)
+		default:
+			panic(fmt.Errorf("Unknown orders broker %s", cfg.Orders.Broker))
+		}
+		defer consumer.Close()
+		defer replier.Close()
+		ordersService := orders.NewService(ordersdb.NewStorage(database, logger), service, consumer, replier, logger)
+		go ordersService.Run(context.Background())
+	}
+
 	start(router, cfg)
 }
 
-func start(router *httprouter.Router, cfg *config.Config) {
+func start(router *handlers.Router, cfg *config.Config) {
 	logger := logging.NewLogger()
 
 	logger.Infof("Start application on %s:%s", cfg.Listen.BindIp, cfg.Listen.Port)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+	"user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/config"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+// Rebuilds the daily revenue rollups from the bookkeeping rows. The range is set by
+// the -from and -to days (2006-01-02, to is exclusive), the whole table is rebuilt without them
+func main() {
+	from := flag.String("from", "", "first day of the range")
+	to := flag.String("to", "", "day after the range")
+	flag.Parse()
+
+	logger := logging.NewLogger()
+
+	var start, end time.Time
+	var err error
+	if *from != "" {
+		if start, err = time.Parse("2006-01-02", *from); err != nil {
+			logger.Fatalf("Invalid -from %s", *from)
+		}
+	}
+	if *to != "" {
+		if end, err = time.Parse("2006-01-02", *to); err != nil {
+			logger.Fatalf("Invalid -to %s", *to)
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		logger.Fatalf("Empty range from %s to %s", *from, *to)
+	}
+
+	cfg := config.GetConfig()
+
+	database, err := mysql.NewClient(
+		context.Background(),
+		cfg.Database.Host,
+		cfg.Database.Port,
+		cfg.Database.Username,
+		cfg.Database.Password,
+		cfg.Database.Database)
+	if err != nil {
+		panic(err)
+	}
+	defer database.Close()
+
+	rows, err := db.RebuildRevenueDaily(context.Background(), database, start, end)
+	if err != nil {
+		logger.Fatalf("Rebuilding revenue rollups failed: %s", err)
+	}
+	logger.Infof("Rebuilt %d daily revenue rows", rows)
+}
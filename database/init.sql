@@ -17,22 +17,47 @@ CREATE TABLE IF NOT EXISTS reserve_account (
     FOREIGN KEY (service_user_id) REFERENCES service_user(id)
 );
 
+CREATE TABLE IF NOT EXISTS bonus_account (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    service_user_id INT,
+    amount DECIMAL(15,2) UNSIGNED,
+    balance DECIMAL(15,2) UNSIGNED,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+);
+
 CREATE TABLE IF NOT EXISTS reservation (
     id INT PRIMARY KEY AUTO_INCREMENT,
     service_id INT NOT NULL,
     order_id INT NOT NULL,
     service_user_id INT,
     amount DECIMAL(15,2) UNSIGNED,
+    bonus_amount DECIMAL(15,2) UNSIGNED DEFAULT 0,
     FOREIGN KEY (service_user_id) REFERENCES service_user(id)
 );
 
+CREATE TABLE IF NOT EXISTS reservation_bonus (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    reservation_id INT NOT NULL,
+    bonus_account_id INT NOT NULL,
+    amount DECIMAL(15,2) UNSIGNED NOT NULL,
+    FOREIGN KEY (reservation_id) REFERENCES reservation(id) ON DELETE CASCADE,
+    FOREIGN KEY (bonus_account_id) REFERENCES bonus_account(id)
+);
+
 CREATE TABLE IF NOT EXISTS bookkeeping (
     id INT PRIMARY KEY AUTO_INCREMENT,
     service_user_id INT,
     service_id INT NOT NULL,
-    amount DECIMAL(15,2) UNSIGNED,
+    amount DECIMAL(15,2),
+    adjusts_period CHAR(7) NULL,
+    reason VARCHAR(255) NULL,
+    created_by VARCHAR(255) NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id),
+    INDEX (created_at, service_id),
+    INDEX (adjusts_period)
 );
 
 CREATE TABLE IF NOT EXISTS user_report (
@@ -40,14 +65,233 @@ CREATE TABLE IF NOT EXISTS user_report (
     service_user_id INT,
     amount DECIMAL(15,2) UNSIGNED,
     description TEXT NOT NULL,
+    operation_type VARCHAR(20) NOT NULL DEFAULT '',
+    direction VARCHAR(10) NOT NULL DEFAULT '',
+    counterparty_id INT NOT NULL DEFAULT 0,
+    service_id INT NOT NULL DEFAULT 0,
+    order_id INT NOT NULL DEFAULT 0,
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    balance_after DECIMAL(15,2) NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id),
+    INDEX (service_user_id, created_at, id),
+    INDEX (service_user_id, amount, id)
 );
 
+CREATE TABLE IF NOT EXISTS revenue_daily (
+    day DATE NOT NULL,
+    service_id INT NOT NULL,
+    revenue DECIMAL(17,2) NOT NULL DEFAULT 0,
+    orders INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, service_id)
+);
+-- init.sql runs only on an empty database. A database created before the rollup gets this table
+-- and is filled with go run cmd/rollup/main.go before the new version of the service starts
+
 CREATE TABLE IF NOT EXISTS bookkeeping_report (
     id INT PRIMARY KEY AUTO_INCREMENT,
-    hash_string VARCHAR(255),
-    path_to_file VARCHAR(255)
+    report_key VARCHAR(64) NOT NULL UNIQUE,
+    hash_string VARCHAR(64),
+    path_to_file VARCHAR(255),
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    granularity VARCHAR(16) NOT NULL DEFAULT '',
+    format VARCHAR(8) NOT NULL DEFAULT 'csv',
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (hash_string)
+);
+
+CREATE TABLE IF NOT EXISTS report_job (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    period VARCHAR(16) NOT NULL DEFAULT '',
+    date_from VARCHAR(10) NOT NULL DEFAULT '',
+    date_to VARCHAR(10) NOT NULL DEFAULT '',
+    granularity VARCHAR(16) NOT NULL DEFAULT '',
+    format VARCHAR(8) NOT NULL DEFAULT '',
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL,
+    report_key VARCHAR(64) NOT NULL DEFAULT '',
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    active_key VARCHAR(64) UNIQUE,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (status, id)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_report (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    period VARCHAR(7) NOT NULL UNIQUE,
+    report_key VARCHAR(64) NOT NULL,
+    notified BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_report_delivery (
+    scheduled_report_id INT NOT NULL,
+    notifier VARCHAR(32) NOT NULL,
+    delivered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scheduled_report_id, notifier),
+    FOREIGN KEY (scheduled_report_id) REFERENCES scheduled_report(id)
+);
+
+CREATE TABLE IF NOT EXISTS promo_code (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    amount DECIMAL(15,2) UNSIGNED,
+    usage_limit INT UNSIGNED NOT NULL,
+    per_user_limit INT UNSIGNED NOT NULL,
+    used_count INT UNSIGNED NOT NULL DEFAULT 0,
+    valid_from TIMESTAMP NOT NULL,
+    valid_to TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemption (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    promo_code_id INT,
+    service_user_id INT,
+    amount DECIMAL(15,2) UNSIGNED,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (promo_code_id) REFERENCES promo_code(id),
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+);
+
+CREATE TABLE IF NOT EXISTS payment (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    service_user_id INT,
+    amount DECIMAL(15,2) UNSIGNED,
+    status VARCHAR(20) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    provider_payment_id VARCHAR(255) NOT NULL DEFAULT '',
+    confirmation_url VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (provider, provider_payment_id),
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
 );
 
+CREATE TABLE IF NOT EXISTS payout (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    service_user_id INT,
+    amount DECIMAL(15,2) UNSIGNED,
+    destination VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    gateway VARCHAR(50) NOT NULL,
+    gateway_ref VARCHAR(255) NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    resent BOOLEAN NOT NULL DEFAULT FALSE,
+    next_poll_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (status),
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    url VARCHAR(1024) NOT NULL,
+    events VARCHAR(255) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    subscription_id INT NOT NULL,
+    message_id BIGINT NOT NULL,
+    event_type VARCHAR(20) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    last_error VARCHAR(1024) NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (subscription_id, message_id),
+    INDEX (status, next_attempt_at),
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscription(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    service_user_id INT,
+    event_type VARCHAR(20) NOT NULL,
+    payload JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP NULL,
+    INDEX (published_at, id)
+);
+
+CREATE TABLE IF NOT EXISTS processed_message (
+    message_id VARCHAR(255) PRIMARY KEY,
+    reply JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_statement (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    service_user_id INT NOT NULL,
+    period CHAR(7) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    file_key VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (service_user_id, period),
+    FOREIGN KEY (service_user_id) REFERENCES service_user(id)
+);
+
+CREATE TABLE IF NOT EXISTS closed_period (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    period CHAR(7) NOT NULL UNIQUE,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    report_key VARCHAR(64) NULL,
+    report_hash VARCHAR(64) NULL,
+    closed_by VARCHAR(255) NOT NULL,
+    closed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (period_start, period_end)
+);
+
+-- bookkeeping rows dated in a closed period can not be added, changed or removed,
+-- corrections are written to an open period with adjusts_period set
+DELIMITER //
+CREATE TRIGGER bookkeeping_closed_insert BEFORE INSERT ON bookkeeping FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE coalesce(NEW.created_at, now()) >= period_start AND coalesce(NEW.created_at, now()) < period_end) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+CREATE TRIGGER bookkeeping_closed_update BEFORE UPDATE ON bookkeeping FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE (OLD.created_at >= period_start AND OLD.created_at < period_end) OR (NEW.created_at >= period_start AND NEW.created_at < period_end)) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+CREATE TRIGGER bookkeeping_closed_delete BEFORE DELETE ON bookkeeping FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE OLD.created_at >= period_start AND OLD.created_at < period_end) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+-- the daily revenue of a closed period is frozen like its bookkeeping, the reports are built from it
+CREATE TRIGGER revenue_daily_closed_insert BEFORE INSERT ON revenue_daily FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE NEW.day >= period_start AND NEW.day < period_end) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+CREATE TRIGGER revenue_daily_closed_update BEFORE UPDATE ON revenue_daily FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE (OLD.day >= period_start AND OLD.day < period_end) OR (NEW.day >= period_start AND NEW.day < period_end)) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+CREATE TRIGGER revenue_daily_closed_delete BEFORE DELETE ON revenue_daily FOR EACH ROW
+BEGIN
+    IF EXISTS (SELECT 1 FROM closed_period WHERE OLD.day >= period_start AND OLD.day < period_end) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Accounting period is closed';
+    END IF;
+END//
+DELIMITER ;
+
 INSERT INTO service_user (username) VALUES ("user1"), ("user2"), ("user3"), ("user4");
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"user-balance-service/internal/analytics"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/pkg/logging"
+)
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+// The revenue and the orders are summed from the daily rollups, the distinct paying users
+// can only be counted over the bookkeeping rows
+const (
+	rollupTable      = `revenue_daily`
+	rollupDate       = `day`
+	bookkeepingTable = `bookkeeping`
+	bookkeepingDate  = `created_at`
+)
+
+// where selects the rows of the filter, column is the date of the row
+func where(filter *analytics.Filter, column string) (string, []any) {
+	query := ` where ` + column + ` >= ? and ` + column + ` < ?`
+	args := []any{filter.Start, filter.End}
+	if filter.ServiceId != 0 {
+		query += ` and service_id = ?`
+		args = append(args, filter.ServiceId)
+	}
+	return query, args
+}
+
+// period returns the select expression of the period, the rows of the whole range
+// are grouped by a constant when there is no granularity
+func period(filter *analytics.Filter, column string) (string, error) {
+	if filter.Granularity == cashaccount.GranularityNone {
+		return `date(?)`, nil
+	}
+	expr, ok := cashaccountdb.GranularityPeriod(filter.Granularity, column)
+	if !ok {
+		return "", apperror.ErrBadRequest
+	}
+	return expr, nil
+}
+
+// query runs the aggregation of the table grouped by the period, scan reads the period and the columns of a row
+func (d *db) query(ctx context.Context, filter *analytics.Filter, table, column, columns, groupBy string, scan func(rows *sql.Rows) error) error {
+	expr, err := period(filter, column)
+	if err != nil {
+		return err
+	}
+	cond, condArgs := where(filter, column)
+	args := make([]any, 0, len(condArgs)+1)
+	if filter.Granularity == cashaccount.GranularityNone {
+		args = append(args, filter.Start)
+	}
+	args = append(args, condArgs...)
+
+	rows, err := d.QueryContext(ctx, `select `+expr+` as period, `+columns+` from `+table+cond+
+		` group by period`+groupBy+` order by period`+groupBy+`;`, args...)
+	if err != nil {
+		d.logger.Errorf("Error %s in revenue analytics", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (d *db) Revenue(ctx context.Context, filter *analytics.Filter) ([]*analytics.RevenuePoint, error) {
+	res := make([]*analytics.RevenuePoint, 0)
+	err := d.query(ctx, filter, rollupTable, rollupDate, `service_id, sum(revenue), sum(orders)`, `, service_id`, func(rows *sql.Rows) error {
+		item := new(analytics.RevenuePoint)
+		if err := rows.Scan(&item.Period, &item.ServiceId, &item.Revenue, &item.Orders); err != nil {
+			return err
+		}
+		res = append(res, item)
+		return nil
+	})
+	return res, err
+}
+
+func (d *db) PayingUsers(ctx context.Context, filter *analytics.Filter) ([]*analytics.PayingUsersPoint, error) {
+	res := make([]*analytics.PayingUsersPoint, 0)
+	err := d.query(ctx, filter, bookkeepingTable, bookkeepingDate, `count(distinct service_user_id)`, ``, func(rows *sql.Rows) error {
+		item := new(analytics.PayingUsersPoint)
+		if err := rows.Scan(&item.Period, &item.Users); err != nil {
+			return err
+		}
+		res = append(res, item)
+		return nil
+	})
+	return res, err
+}
+
+func (d *db) AverageCheck(ctx context.Context, filter *analytics.Filter) ([]*analytics.CheckPoint, error) {
+	res := make([]*analytics.CheckPoint, 0)
+	err := d.query(ctx, filter, rollupTable, rollupDate, `sum(orders), sum(revenue), sum(revenue) / sum(orders)`, ``, func(rows *sql.Rows) error {
+		item := new(analytics.CheckPoint)
+		if err := rows.Scan(&item.Period, &item.Orders, &item.Revenue, &item.Average); err != nil {
+			return err
+		}
+		res = append(res, item)
+		return nil
+	})
+	return res, err
+}
+
+func (d *db) TopServices(ctx context.Context, filter *analytics.Filter) ([]*analytics.ServiceRevenue, error) {
+	cond, args := where(filter, rollupDate)
+	rows, err := d.QueryContext(ctx, `select service_id, sum(revenue) as total, sum(orders), sum(revenue) / (select sum(revenue) from revenue_daily`+cond+`)
+		from revenue_daily`+cond+` group by service_id order by total desc, service_id limit ?;`, append(append(args, args...), filter.Limit)...)
+	if err != nil {
+		d.logger.Errorf("Error %s in top services", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*analytics.ServiceRevenue, 0)
+	for rows.Next() {
+		item := new(analytics.ServiceRevenue)
+		if err := rows.Scan(&item.ServiceId, &item.Revenue, &item.Orders, &item.Share); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) Totals(ctx context.Context, filter *analytics.Filter) (*analytics.Totals, error) {
+	res := new(analytics.Totals)
+	cond, args := where(filter, rollupDate)
+	row := d.QueryRowContext(ctx, `select coalesce(sum(revenue), 0), coalesce(sum(orders), 0), coalesce(sum(revenue) / sum(orders), 0) from revenue_daily`+cond+`;`, args...)
+	if err := row.Scan(&res.Revenue, &res.Orders, &res.AverageCheck); err != nil {
+		return nil, err
+	}
+
+	cond, args = where(filter, bookkeepingDate)
+	row = d.QueryRowContext(ctx, `select count(distinct service_user_id) from bookkeeping`+cond+`;`, args...)
+	if err := row.Scan(&res.PayingUsers); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) analytics.Storage {
+	return &db{database, logger}
+}
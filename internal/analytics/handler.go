@@ -0,0 +1,129 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+)
+
+type handler struct {
+	service *Service
+	logger  *logging.Logger
+}
+
+func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodGet, "/api/analytics/revenue", middleware.Middleware(h.Revenue))
+	router.HandlerFunc(http.MethodGet, "/api/analytics/top-services", middleware.Middleware(h.TopServices))
+	router.HandlerFunc(http.MethodGet, "/api/analytics/paying-users", middleware.Middleware(h.PayingUsers))
+	router.HandlerFunc(http.MethodGet, "/api/analytics/average-check", middleware.Middleware(h.AverageCheck))
+	router.HandlerFunc(http.MethodGet, "/api/analytics/month-over-month", middleware.Middleware(h.CompareMonths))
+}
+
+func parseUint(query url.Values, name string) (uint32, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	res, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, apperror.ErrBadRequest
+	}
+	return uint32(res), nil
+}
+
+func parseQuery(r *http.Request) (*Query, error) {
+	query := r.URL.Query()
+	q := &Query{
+		Period:      query.Get("period"),
+		From:        query.Get("from"),
+		To:          query.Get("to"),
+		Granularity: cashaccount.Granularity(query.Get("granularity")),
+	}
+	var err error
+	if q.ServiceId, err = parseUint(query, "serviceId"); err != nil {
+		return nil, err
+	}
+	if q.Limit, err = parseUint(query, "limit"); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (h *handler) Revenue(w http.ResponseWriter, r *http.Request) error {
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	res, err := h.service.Revenue(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (h *handler) TopServices(w http.ResponseWriter, r *http.Request) error {
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	res, err := h.service.TopServices(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (h *handler) PayingUsers(w http.ResponseWriter, r *http.Request) error {
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	res, err := h.service.PayingUsers(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (h *handler) AverageCheck(w http.ResponseWriter, r *http.Request) error {
+	q, err := parseQuery(r)
+	if err != nil {
+		return err
+	}
+	res, err := h.service.AverageCheck(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (h *handler) CompareMonths(w http.ResponseWriter, r *http.Request) error {
+	serviceId, err := parseUint(r.URL.Query(), "serviceId")
+	if err != nil {
+		return err
+	}
+	res, err := h.service.CompareMonths(context.Background(), r.URL.Query().Get("month"), serviceId)
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
@@ -0,0 +1,79 @@
+package analytics
+
+import (
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+)
+
+// Query selects the revenue of the period, Period, From, To and Granularity
+// are the same as the parameters of the bookkeeping report
+type Query struct {
+	Period      string
+	From        string
+	To          string
+	Granularity cashaccount.Granularity
+	// ServiceId limits the revenue to one service when it is not zero
+	ServiceId uint32
+	// Limit is the number of the top services
+	Limit uint32
+}
+
+// Filter is a validated query, End is exclusive
+type Filter struct {
+	Start       time.Time
+	End         time.Time
+	Granularity cashaccount.Granularity
+	ServiceId   uint32
+	Limit       uint32
+}
+
+// RevenuePoint is the revenue of the service in the period starting at Period,
+// which is the start of the range when there is no granularity
+type RevenuePoint struct {
+	Period    time.Time `json:"period"`
+	ServiceId uint32    `json:"service_id"`
+	Revenue   float64   `json:"revenue"`
+	Orders    uint32    `json:"orders"`
+}
+
+type ServiceRevenue struct {
+	ServiceId uint32  `json:"service_id"`
+	Revenue   float64 `json:"revenue"`
+	Orders    uint32  `json:"orders"`
+	// Share is the part of the revenue of all services from 0 to 1
+	Share float64 `json:"share"`
+}
+
+type PayingUsersPoint struct {
+	Period time.Time `json:"period"`
+	Users  uint32    `json:"users"`
+}
+
+// CheckPoint is the average amount of an accepted order
+type CheckPoint struct {
+	Period  time.Time `json:"period"`
+	Orders  uint32    `json:"orders"`
+	Revenue float64   `json:"revenue"`
+	Average float64   `json:"average"`
+}
+
+type Totals struct {
+	Revenue      float64 `json:"revenue"`
+	Orders       uint32  `json:"orders"`
+	PayingUsers  uint32  `json:"paying_users"`
+	AverageCheck float64 `json:"average_check"`
+}
+
+// MonthComparison compares the month with the previous one, the percents
+// are nil when the previous month has nothing to compare with
+type MonthComparison struct {
+	Month                     string   `json:"month"`
+	PreviousMonth             string   `json:"previous_month"`
+	ServiceId                 uint32   `json:"service_id,omitempty"`
+	Current                   *Totals  `json:"current"`
+	Previous                  *Totals  `json:"previous"`
+	RevenueChange             float64  `json:"revenue_change"`
+	RevenueChangePercent      *float64 `json:"revenue_change_percent"`
+	PayingUsersChangePercent  *float64 `json:"paying_users_change_percent"`
+	AverageCheckChangePercent *float64 `json:"average_check_change_percent"`
+}
@@ -0,0 +1,118 @@
+package analytics
+
+import (
+	"context"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+const (
+	defaultTopLimit = 10
+	maxTopLimit     = 100
+)
+
+type Service struct {
+	storage Storage
+	logger  *logging.Logger
+}
+
+// filter validates the query in the same way as the period of the bookkeeping report
+func filter(q *Query) (*Filter, error) {
+	period, err := cashaccount.ParseReportPeriod(&cashaccount.BookkeepingReportRequest{
+		Period:      q.Period,
+		From:        q.From,
+		To:          q.To,
+		Granularity: q.Granularity,
+	})
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit == 0 {
+		limit = defaultTopLimit
+	}
+	if limit > maxTopLimit {
+		return nil, apperror.ErrBadRequest
+	}
+	return &Filter{Start: period.Start, End: period.End, Granularity: period.Granularity, ServiceId: q.ServiceId, Limit: limit}, nil
+}
+
+func (s *Service) Revenue(ctx context.Context, q *Query) ([]*RevenuePoint, error) {
+	f, err := filter(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.Revenue(ctx, f)
+}
+
+func (s *Service) TopServices(ctx context.Context, q *Query) ([]*ServiceRevenue, error) {
+	f, err := filter(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.TopServices(ctx, f)
+}
+
+func (s *Service) PayingUsers(ctx context.Context, q *Query) ([]*PayingUsersPoint, error) {
+	f, err := filter(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.PayingUsers(ctx, f)
+}
+
+func (s *Service) AverageCheck(ctx context.Context, q *Query) ([]*CheckPoint, error) {
+	f, err := filter(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.AverageCheck(ctx, f)
+}
+
+// changePercent is nil when there is nothing to compare with
+func changePercent(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	res := (current - previous) / previous * 100
+	return &res
+}
+
+// CompareMonths compares the month (2006-01) with the previous one
+func (s *Service) CompareMonths(ctx context.Context, month string, serviceId uint32) (*MonthComparison, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	previousStart := start.AddDate(0, -1, 0)
+
+	current, err := s.storage.Totals(ctx, &Filter{Start: start, End: start.AddDate(0, 1, 0), ServiceId: serviceId})
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.storage.Totals(ctx, &Filter{Start: previousStart, End: start, ServiceId: serviceId})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MonthComparison{
+		Month:                     month,
+		PreviousMonth:             previousStart.Format("2006-01"),
+		ServiceId:                 serviceId,
+		Current:                   current,
+		Previous:                  previous,
+		RevenueChange:             current.Revenue - previous.Revenue,
+		RevenueChangePercent:      changePercent(current.Revenue, previous.Revenue),
+		PayingUsersChangePercent:  changePercent(float64(current.PayingUsers), float64(previous.PayingUsers)),
+		AverageCheckChangePercent: changePercent(current.AverageCheck, previous.AverageCheck),
+	}, nil
+}
+
+func NewService(st Storage, logger *logging.Logger) *Service {
+	return &Service{
+		storage: st,
+		logger:  logger,
+	}
+}
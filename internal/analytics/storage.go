@@ -0,0 +1,13 @@
+package analytics
+
+import "context"
+
+// Storage aggregates the daily revenue rollups and the bookkeeping table, the periods of the points are in chronological order
+type Storage interface {
+	Revenue(ctx context.Context, filter *Filter) ([]*RevenuePoint, error)
+	// TopServices returns the services with the largest revenue first
+	TopServices(ctx context.Context, filter *Filter) ([]*ServiceRevenue, error)
+	PayingUsers(ctx context.Context, filter *Filter) ([]*PayingUsersPoint, error)
+	AverageCheck(ctx context.Context, filter *Filter) ([]*CheckPoint, error)
+	Totals(ctx context.Context, filter *Filter) (*Totals, error)
+}
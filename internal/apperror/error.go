@@ -5,6 +5,8 @@ import "encoding/json"
 var (
 	ErrNotFound   = NewAppError(nil, "not found", "BS-000001")
 	ErrBadRequest = NewAppError(nil, "bad request", "BS-000002")
+	ErrConflict   = NewAppError(nil, "conflict", "BS-000003")
+	ErrForbidden  = NewAppError(nil, "forbidden", "BS-000004")
 )
 
 type AppError struct {
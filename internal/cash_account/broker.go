@@ -0,0 +1,62 @@
+package cashaccount
+
+import (
+	"context"
+	"sync"
+	"user-balance-service/internal/events"
+)
+
+// Broker notifies the subscribers of a user when the balance of the user changes.
+// It listens to the event bus, so every Service method which publishes an event triggers a notification
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[uint32]map[chan struct{}]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[uint32]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel which receives a value after every change of the user balance
+// and a function which removes the subscription
+func (b *Broker) Subscribe(userId uint32) (<-chan struct{}, func()) {
+	// a single buffered value is enough, the subscriber reads the current state anyway
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subscribers[userId] == nil {
+		b.subscribers[userId] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userId][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[userId], ch)
+		if len(b.subscribers[userId]) == 0 {
+			delete(b.subscribers, userId)
+		}
+	}
+}
+
+// Subscribers returns the number of subscribers of the user
+func (b *Broker) Subscribers(userId uint32) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers[userId])
+}
+
+// Handle never blocks, so a slow subscriber does not delay the request which changed the balance
+func (b *Broker) Handle(ctx context.Context, e *events.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, userId := range e.Users() {
+		for ch := range b.subscribers[userId] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cashaccount
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"user-balance-service/internal/apperror"
+)
+
+// UserReportCursor points to the last row of a page of the user report.
+// The next page starts right after the row in the order of the report
+type UserReportCursor struct {
+	SortBy    string `json:"s"`
+	Direction string `json:"d"`
+	// Key is the value of the sort column of the row, empty when the report is ordered by id
+	Key string `json:"k,omitempty"`
+	ID  uint32 `json:"i"`
+}
+
+// cursorTimeFormat matches the precision of user_report.created_at
+const cursorTimeFormat = "2006-01-02 15:04:05"
+
+func newUserReportCursor(row *UserReportRow, sortBy, sortDirection string) *UserReportCursor {
+	c := &UserReportCursor{SortBy: sortBy, Direction: sortDirection, ID: row.ID}
+	switch sortBy {
+	case "created_at":
+		c.Key = row.DateTime.Format(cursorTimeFormat)
+	case "amount":
+		c.Key = fmt.Sprintf("%.2f", row.Amount)
+	}
+	return c
+}
+
+func (c *UserReportCursor) Encode() string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// DecodeUserReportCursor parses the opaque cursor and checks that it was issued for the same order
+func DecodeUserReportCursor(value, sortBy, sortDirection string) (*UserReportCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	c := new(UserReportCursor)
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	if c.SortBy != sortBy || c.Direction != sortDirection || c.ID == 0 {
+		return nil, apperror.ErrBadRequest
+	}
+	if c.SortBy != "" && c.Key == "" {
+		return nil, apperror.ErrBadRequest
+	}
+	return c, nil
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/events"
+	outboxdb "user-balance-service/internal/outbox/db"
+)
+
+// bonusBalance returns the sum of not expired bonuses of the user and locks them until the end of tx
+func bonusBalance(tx *sql.Tx, userId uint32) (float32, error) {
+	var balance float32
+	row := tx.QueryRow(`select coalesce(sum(balance), 0) from bonus_account where service_user_id = ? and balance > 0 and expires_at > now() for update;`, userId)
+	if err := row.Scan(&balance); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// bonusPortion is the part of a bonus spent on a reservation
+type bonusPortion struct {
+	bonusId uint32
+	amount  float32
+}
+
+// spendBonus debits amount from the bonuses of the user starting from the ones which expire first.
+// It returns the debited portions, so that every portion can be returned to its bonus
+func spendBonus(tx *sql.Tx, userId uint32, amount float32) ([]bonusPortion, error) {
+	portions := make([]bonusPortion, 0)
+	if amount <= 0 {
+		return portions, nil
+	}
+
+	rows, err := tx.Query(`select id, balance from bonus_account where service_user_id = ? and balance > 0 and expires_at > now() order by expires_at for update;`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	bonuses := make([]bonusPortion, 0)
+	for rows.Next() {
+		var b bonusPortion
+		if err := rows.Scan(&b.bonusId, &b.amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bonuses = append(bonuses, b)
+	}
+	rows.Close()
+
+	for _, b := range bonuses {
+		if amount <= 0 {
+			break
+		}
+		spent := b.amount
+		if spent > amount {
+			spent = amount
+		}
+		_, err := tx.Exec(`update bonus_account set balance = balance - ? where id = ?;`, spent, b.bonusId)
+		if err != nil {
+			return nil, err
+		}
+		amount -= spent
+		portions = append(portions, bonusPortion{bonusId: b.bonusId, amount: spent})
+	}
+
+	if amount > 0 {
+		return nil, fmt.Errorf("User %d has insufficient bonuses: %w", userId, cashaccount.ErrInsufficientFunds)
+	}
+	return portions, nil
+}
+
+// saveBonusPortions remembers the bonuses spent on the reservation
+func saveBonusPortions(tx *sql.Tx, reservationId int64, portions []bonusPortion) error {
+	for _, p := range portions {
+		_, err := tx.Exec(`insert into reservation_bonus (reservation_id, bonus_account_id, amount) values (?, ?, ?);`, reservationId, p.bonusId, p.amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnBonus gives back bonuses that were spent on a reservation. Every portion goes back to
+// the bonus it was taken from and keeps its expiry
+func returnBonus(tx *sql.Tx, reservationId uint32) error {
+	_, err := tx.Exec(`update bonus_account b join reservation_bonus r on r.bonus_account_id = b.id
+		set b.balance = b.balance + r.amount where r.reservation_id = ?;`, reservationId)
+	return err
+}
+
+// splitReserveAmount returns the part of amount which has to be paid with bonuses
+func splitReserveAmount(priority cashaccount.SpendPriority, balance, bonus, amount float32) float32 {
+	var bonusPart float32
+	if priority == cashaccount.MainFirst {
+		bonusPart = amount - balance
+	} else {
+		bonusPart = bonus
+	}
+	if bonusPart > amount {
+		bonusPart = amount
+	}
+	if bonusPart > bonus {
+		bonusPart = bonus
+	}
+	if bonusPart < 0 {
+		bonusPart = 0
+	}
+	return bonusPart
+}
+
+func (d *db) TopUpBonus(ctx context.Context, data *cashaccount.BonusAmount) error {
+	err := isUserExsists(d, data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = d.execWithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`insert into bonus_account (service_user_id, amount, balance, expires_at) values (?, ?, ?, ?);`, data.ID, data.Amount, data.Amount, data.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		err = UpdateUserReport(tx, data.ID, data.Amount, &cashaccount.Operation{Type: cashaccount.OperationBonusTopUp, Direction: cashaccount.Credit, Reference: data.ExpiresAt.Format("2006-01-02 15:04:05")})
+		if err != nil {
+			return err
+		}
+
+		err = outboxdb.Save(tx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: data.Amount, Source: "bonus"})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in bonus topup to user: %d amount: %f", err, data.ID, data.Amount)
+	} else {
+		d.logger.Infof("Bonus topup to user: %d amount: %f", data.ID, data.Amount)
+	}
+	return err
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 	"user-balance-service/internal/apperror"
 	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/events"
+	outboxdb "user-balance-service/internal/outbox/db"
 	"user-balance-service/pkg/logging"
 )
 
@@ -26,8 +30,17 @@ func isUserExsists(d *db, id uint32) error {
 	return nil
 }
 
-func updateUserReport(tx *sql.Tx, user_id uint32, amount float32, description string) error {
-	r, err := tx.Exec(`insert into user_report (service_user_id, amount, description) values (?, ?, ?)`, user_id, amount, description)
+// UpdateUserReport writes the operation to the history of the user together with the resulting balance.
+// The description is rendered from the operation when the history is read
+func UpdateUserReport(tx *sql.Tx, user_id uint32, amount float32, op *cashaccount.Operation) error {
+	var balanceAfter float32
+	row := tx.QueryRow(`select coalesce((select balance from main_account where service_user_id = ?), 0) + (select coalesce(sum(balance), 0) from bonus_account where service_user_id = ? and expires_at > now());`, user_id, user_id)
+	if err := row.Scan(&balanceAfter); err != nil {
+		return err
+	}
+
+	r, err := tx.Exec(`insert into user_report (service_user_id, amount, description, operation_type, direction, counterparty_id, service_id, order_id, reference, balance_after) values (?, ?, '', ?, ?, ?, ?, ?, ?, ?)`,
+		user_id, amount, op.Type, op.Direction, op.CounterpartyId, op.ServiceId, op.OrderId, op.Reference, balanceAfter)
 	if err != nil {
 		return err
 	}
@@ -39,12 +52,22 @@ func updateUserReport(tx *sql.Tx, user_id uint32, amount float32, description st
 }
 
 func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return d.execWithResult(ctx, nil, fn)
+}
+
+// execWithResult is execWithTx which passes the result of the operation to the hook of the context
+func (d *db) execWithResult(ctx context.Context, result error, fn func(tx *sql.Tx) error) error {
 	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: 0})
 	if err != nil {
 		return err
 	}
 
 	err = fn(tx)
+	if err == nil {
+		if hook, ok := ctx.Value(txHookKey{}).(func(tx *sql.Tx, result error) error); ok {
+			err = hook(tx, result)
+		}
+	}
 
 	if err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
@@ -56,37 +79,52 @@ func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
 	return tx.Commit()
 }
 
+type txHookKey struct{}
+
+// WithTxHook returns the context in which the hook is executed in the transaction of the operation
+// before it is committed, so the writes of the caller are committed together with the operation.
+// The result is cashaccount.ErrUnreserved when AcceptRevenue returns the reserved money instead, otherwise nil
+func WithTxHook(ctx context.Context, hook func(tx *sql.Tx, result error) error) context.Context {
+	return context.WithValue(ctx, txHookKey{}, hook)
+}
+
+// TopUp credits the main account of the user and writes the operation to the user report.
+// Every subsystem which replenishes the balance goes through it
+func TopUp(tx *sql.Tx, userId uint32, amount float32, op *cashaccount.Operation) error {
+	var count int
+	row := tx.QueryRow(`select count(id) from main_account where service_user_id = ?;`, userId)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		_, err := tx.Exec(`insert into main_account (balance, service_user_id) values (?, ?);`, amount, userId)
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := tx.Exec(`update main_account set balance = balance + ? where service_user_id = ?;`, amount, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return UpdateUserReport(tx, userId, amount, op)
+}
+
 func (d *db) TopUpMoney(ctx context.Context, data *cashaccount.UserAmount) error {
 	err := isUserExsists(d, data.ID)
 	if err != nil {
 		return err
 	}
-	var count int
-	row := d.QueryRow(`select count(id) from main_account where service_user_id = ?;`, data.ID)
-	if err = row.Scan(&count); err != nil {
-		return err
-	}
 
 	err = d.execWithTx(ctx, func(tx *sql.Tx) error {
-
-		if count == 0 {
-			_, err := tx.Exec(`insert into main_account (balance, service_user_id) values (?, ?);`, data.Amount, data.ID)
-			if err != nil {
-				return err
-			}
-		} else {
-			_, err := tx.Exec(`update main_account set balance = balance + ? where service_user_id = ?;`, data.Amount, data.ID)
-			if err != nil {
-				return err
-			}
-		}
-
-		err = updateUserReport(tx, data.ID, float32(data.Amount), fmt.Sprintf("Account replenished"))
+		err := TopUp(tx, data.ID, data.Amount, &cashaccount.Operation{Type: cashaccount.OperationTopUp, Direction: cashaccount.Credit})
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return outboxdb.Save(tx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: data.Amount})
 	})
 	if err != nil {
 		d.logger.Errorf("Error %s in topup to user: %d amount: %f", err, data.ID, data.Amount)
@@ -115,7 +153,7 @@ func (d *db) WithdrawMoney(ctx context.Context, data *cashaccount.UserAmount) er
 		} else {
 
 			if balance-data.Amount < 0 {
-				return fmt.Errorf("Withdraw amount is greater than balance")
+				return fmt.Errorf("Withdraw amount is greater than balance: %w", cashaccount.ErrInsufficientFunds)
 			}
 
 			_, err := tx.Exec(`update main_account set balance = balance - ? where service_user_id = ?;`, data.Amount, data.ID)
@@ -124,7 +162,12 @@ func (d *db) WithdrawMoney(ctx context.Context, data *cashaccount.UserAmount) er
 			}
 		}
 
-		err = updateUserReport(tx, data.ID, float32(data.Amount), fmt.Sprintf("Debiting money from an account"))
+		err = UpdateUserReport(tx, data.ID, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationWithdraw, Direction: cashaccount.Debit})
+		if err != nil {
+			return err
+		}
+
+		err = outboxdb.Save(tx, &events.Event{Type: events.Withdraw, UserId: data.ID, Amount: data.Amount})
 		if err != nil {
 			return err
 		}
@@ -139,8 +182,8 @@ func (d *db) WithdrawMoney(ctx context.Context, data *cashaccount.UserAmount) er
 	return err
 }
 
-func (d *db) GetAmount(ctx context.Context, id uint32) (*cashaccount.UserAmount, error) {
-	userAmount := &cashaccount.UserAmount{}
+func (d *db) GetAmount(ctx context.Context, id uint32) (*cashaccount.UserBalance, error) {
+	userAmount := &cashaccount.UserBalance{}
 	userAmount.ID = id
 
 	err := isUserExsists(d, id)
@@ -164,6 +207,11 @@ func (d *db) GetAmount(ctx context.Context, id uint32) (*cashaccount.UserAmount,
 		userAmount.Amount = balance
 	}
 
+	row = d.QueryRow(`select coalesce(sum(balance), 0) from bonus_account where service_user_id = ? and expires_at > now();`, id)
+	if err = row.Scan(&userAmount.Bonus); err != nil {
+		return nil, err
+	}
+
 	return userAmount, err
 }
 
@@ -207,8 +255,13 @@ func (d *db) TransferBetweenUsers(ctx context.Context, data *cashaccount.MoneyTr
 		if count != 2 {
 			return fmt.Errorf("One of user have not main account")
 		} else {
+			// bonuses are not transferable, so only the main account is charged
 			if balances[data.FromId] < data.Amount {
-				return fmt.Errorf("User %d has insufficient funds", data.FromId)
+				bonus, err := bonusBalance(tx, data.FromId)
+				if err == nil && balances[data.FromId]+bonus >= data.Amount {
+					return fmt.Errorf("User %d has insufficient funds, bonuses can not be transferred: %w", data.FromId, cashaccount.ErrInsufficientFunds)
+				}
+				return fmt.Errorf("User %d has insufficient funds: %w", data.FromId, cashaccount.ErrInsufficientFunds)
 			}
 			_, err5 := tx.Exec(`update main_account set balance = balance - ? where service_user_id = ?;`, data.Amount, data.FromId)
 			if err5 != nil {
@@ -218,11 +271,20 @@ func (d *db) TransferBetweenUsers(ctx context.Context, data *cashaccount.MoneyTr
 			if err5 != nil {
 				return err5
 			}
-			err = updateUserReport(tx, data.FromId, float32(data.Amount), fmt.Sprintf("Transferring money to a user %d", data.ToId))
+			err = UpdateUserReport(tx, data.FromId, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationTransfer, Direction: cashaccount.Debit, CounterpartyId: data.ToId})
+			if err != nil {
+				return err
+			}
+			err = UpdateUserReport(tx, data.ToId, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationTransfer, Direction: cashaccount.Credit, CounterpartyId: data.FromId})
 			if err != nil {
 				return err
 			}
-			err = updateUserReport(tx, data.ToId, float32(data.Amount), fmt.Sprintf("Receiving money from the user %d", data.FromId))
+			// the events are keyed by the user, so each side of the transfer gets its own event
+			err = outboxdb.Save(tx, &events.Event{Type: events.Transfer, UserId: data.FromId, CounterpartyId: data.ToId, Direction: events.Debit, Amount: data.Amount})
+			if err != nil {
+				return err
+			}
+			err = outboxdb.Save(tx, &events.Event{Type: events.Transfer, UserId: data.ToId, CounterpartyId: data.FromId, Direction: events.Credit, Amount: data.Amount})
 			if err != nil {
 				return err
 			}
@@ -237,26 +299,37 @@ func (d *db) TransferBetweenUsers(ctx context.Context, data *cashaccount.MoneyTr
 	return err
 }
 
-func (d *db) ReserveMoney(ctx context.Context, data *cashaccount.ReserveDetails) error {
+func (d *db) ReserveMoney(ctx context.Context, data *cashaccount.ReserveDetails, priority cashaccount.SpendPriority) error {
 	err := isUserExsists(d, data.ID)
 	if err != nil {
 		return err
 	}
-	var balance float32
-	row := d.QueryRow(`select balance from main_account where service_user_id = ?;`, data.ID)
-	if err := row.Scan(&balance); err != nil {
-		return err
-	}
-	if balance < data.Amount {
-		return fmt.Errorf("User %d has insufficient funds", data.ID)
-	}
 	var count int
 	row2 := d.QueryRow(`select count(id) from reserve_account where service_user_id = ?;`, data.ID)
 	if err := row2.Scan(&count); err != nil {
 		return err
 	}
 	err = d.execWithTx(ctx, func(tx *sql.Tx) error {
-		_, err := tx.Exec(`update main_account set balance = balance - ? where service_user_id = ?;`, data.Amount, data.ID)
+		var balance float32
+		row := tx.QueryRow(`select balance from main_account where service_user_id = ? for update;`, data.ID)
+		if err := row.Scan(&balance); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		bonus, err := bonusBalance(tx, data.ID)
+		if err != nil {
+			return err
+		}
+		if balance+bonus < data.Amount {
+			return fmt.Errorf("User %d has insufficient funds: %w", data.ID, cashaccount.ErrInsufficientFunds)
+		}
+
+		bonusAmount := splitReserveAmount(priority, balance, bonus, data.Amount)
+		portions, err := spendBonus(tx, data.ID, bonusAmount)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`update main_account set balance = balance - ? where service_user_id = ?;`, data.Amount-bonusAmount, data.ID)
 		if err != nil {
 			return err
 		}
@@ -273,12 +346,24 @@ func (d *db) ReserveMoney(ctx context.Context, data *cashaccount.ReserveDetails)
 			}
 		}
 
-		_, err = tx.Exec(`insert into reservation (service_id, order_id, service_user_id, amount) values (?, ?, ?, ?);`, data.ServiceId, data.OrderId, data.ID, data.Amount)
+		r, err := tx.Exec(`insert into reservation (service_id, order_id, service_user_id, amount, bonus_amount) values (?, ?, ?, ?, ?);`, data.ServiceId, data.OrderId, data.ID, data.Amount, bonusAmount)
+		if err != nil {
+			return err
+		}
+		reservationId, err := r.LastInsertId()
 		if err != nil {
 			return err
 		}
+		if err := saveBonusPortions(tx, reservationId, portions); err != nil {
+			return err
+		}
 
-		err = updateUserReport(tx, data.ID, float32(data.Amount), fmt.Sprintf("The money %f was reserved for the order %d and the service %d", data.Amount, data.OrderId, data.ServiceId))
+		err = UpdateUserReport(tx, data.ID, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationReserve, Direction: cashaccount.Debit, ServiceId: data.ServiceId, OrderId: data.OrderId})
+		if err != nil {
+			return err
+		}
+
+		err = outboxdb.Save(tx, &events.Event{Type: events.Reserve, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
 		if err != nil {
 			return err
 		}
@@ -293,15 +378,50 @@ func (d *db) ReserveMoney(ctx context.Context, data *cashaccount.ReserveDetails)
 	return err
 }
 
+// unreserve returns the reserved money to the accounts it was taken from
+func unreserve(tx *sql.Tx, data *cashaccount.ReserveDetails, reservationId uint32, bonusAmount float32) error {
+	_, err := tx.Exec(`update main_account set balance = balance + ? where service_user_id = ?;`, data.Amount-bonusAmount, data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = returnBonus(tx, reservationId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`update reserve_account set balance = balance - ? where service_user_id = ?;`, data.Amount, data.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`delete from reservation where service_id = ? and order_id = ? and service_user_id = ? and amount = ?`, data.ServiceId, data.OrderId, data.ID, data.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = UpdateUserReport(tx, data.ID, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationUnreserve, Direction: cashaccount.Credit, ServiceId: data.ServiceId, OrderId: data.OrderId})
+	if err != nil {
+		return err
+	}
+
+	return outboxdb.Save(tx, &events.Event{Type: events.Refund, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
+}
+
 func (d *db) AcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails) error {
 	err := isUserExsists(d, data.ID)
 	if err != nil {
 		return err
 	}
 
-	var amount float32
-	reservationRow := d.QueryRow(`select amount from reservation where service_id = ? and order_id = ? and service_user_id = ? and amount = ?;`, data.ServiceId, data.OrderId, data.ID, data.Amount)
-	if err := reservationRow.Scan(&amount); err != nil {
+	var reservationId uint32
+	var amount, bonusAmount float32
+	reservationRow := d.QueryRow(`select id, amount, bonus_amount from reservation where service_id = ? and order_id = ? and service_user_id = ? and amount = ?;`, data.ServiceId, data.OrderId, data.ID, data.Amount)
+	err = reservationRow.Scan(&reservationId, &amount, &bonusAmount)
+	if err == sql.ErrNoRows {
+		return apperror.ErrNotFound
+	}
+	if err != nil {
 		return err
 	}
 
@@ -311,7 +431,7 @@ func (d *db) AcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails
 		return err
 	}
 	if balance < data.Amount {
-		return fmt.Errorf("Incorrect amount (not enough funds)")
+		return fmt.Errorf("Incorrect amount (not enough funds): %w", cashaccount.ErrInsufficientFunds)
 	}
 
 	err = d.execWithTx(ctx, func(tx *sql.Tx) error {
@@ -325,12 +445,17 @@ func (d *db) AcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails
 			return err
 		}
 
-		_, err := tx.Exec(`insert into bookkeeping (service_user_id, service_id, amount) values (?, ?, ?)`, data.ID, data.ServiceId, data.Amount)
+		err := AddRevenue(tx, data.ID, data.ServiceId, data.Amount)
+		if err != nil {
+			return err
+		}
+
+		err = UpdateUserReport(tx, data.ID, float32(data.Amount), &cashaccount.Operation{Type: cashaccount.OperationAccept, Direction: cashaccount.None, ServiceId: data.ServiceId, OrderId: data.OrderId})
 		if err != nil {
 			return err
 		}
 
-		err = updateUserReport(tx, data.ID, float32(data.Amount), fmt.Sprintf("The money %f was accepted for the order %d and the service %d", data.Amount, data.OrderId, data.ServiceId))
+		err = outboxdb.Save(tx, &events.Event{Type: events.Accept, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
 		if err != nil {
 			return err
 		}
@@ -340,29 +465,12 @@ func (d *db) AcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails
 
 	if err != nil {
 		d.logger.Errorf("Error with accept money: %s", err)
-		return d.execWithTx(ctx, func(tx *sql.Tx) error {
-			_, err := tx.Exec(`update main_account set balance = balance + ? where service_user_id = ?;`, data.Amount, data.ID)
-			if err != nil {
-				return err
-			}
-
-			_, err = tx.Exec(`update reserve_account set balance = balance - ? where service_user_id = ?;`, data.Amount, data.ID)
-			if err != nil {
-				return err
-			}
-
-			_, err = tx.Exec(`delete from reservation where service_id = ? and order_id = ? and service_user_id = ? and amount = ?`, data.ServiceId, data.OrderId, data.ID, data.Amount)
-			if err != nil {
-				return err
-			}
-
-			err = updateUserReport(tx, data.ID, float32(data.Amount), fmt.Sprintf("The money %f was unreserved for the order %d and the service %d", data.Amount, data.OrderId, data.ServiceId))
-			if err != nil {
-				return err
-			}
-
-			return nil
+		err = d.execWithResult(ctx, cashaccount.ErrUnreserved, func(tx *sql.Tx) error {
+			return unreserve(tx, data, reservationId, bonusAmount)
 		})
+		if err == nil {
+			err = cashaccount.ErrUnreserved
+		}
 	}
 	if err != nil {
 		d.logger.Errorf("Error %s in accept user: %d, order: %d, service: %d, amount: %f", err, data.ID, data.OrderId, data.ServiceId, data.Amount)
@@ -372,79 +480,316 @@ func (d *db) AcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails
 	return err
 }
 
-func (d *db) GetUserReport(ctx context.Context, uid, rowOffest, pageSize uint32, sortBy, sortDirection string) ([]*cashaccount.UserReportRow, error) {
+func (d *db) CancelReservation(ctx context.Context, data *cashaccount.ReserveDetails) error {
+	err := isUserExsists(d, data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = d.execWithTx(ctx, func(tx *sql.Tx) error {
+		var reservationId uint32
+		var bonusAmount float32
+		row := tx.QueryRow(`select id, bonus_amount from reservation where service_id = ? and order_id = ? and service_user_id = ? and amount = ? limit 1 for update;`, data.ServiceId, data.OrderId, data.ID, data.Amount)
+		err := row.Scan(&reservationId, &bonusAmount)
+		if err == sql.ErrNoRows {
+			return apperror.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return unreserve(tx, data, reservationId, bonusAmount)
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in cancel reservation user: %d, order: %d, service: %d, amount: %f", err, data.ID, data.OrderId, data.ServiceId, data.Amount)
+	} else {
+		d.logger.Infof("Cancel reservation user: %d, order: %d, service: %d, amount: %f", data.ID, data.OrderId, data.ServiceId, data.Amount)
+	}
+	return err
+}
+
+const userReportColumns = `id, amount, description, created_at, operation_type, direction, counterparty_id, service_id, order_id, reference, balance_after`
+
+func scanUserReportRow(row interface{ Scan(...any) error }) (*cashaccount.UserReportRow, error) {
+	item := new(cashaccount.UserReportRow)
+	err := row.Scan(&item.ID, &item.Amount, &item.Description, &item.DateTime, &item.Type, &item.Direction,
+		&item.CounterpartyId, &item.ServiceId, &item.OrderId, &item.Reference, &item.BalanceAfter)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// userReportWhere builds the condition of the user report query, values are always passed as arguments
+func userReportWhere(uid uint32, filter *cashaccount.UserReportFilter) (string, []any) {
+	conds := []string{"service_user_id = ?"}
+	args := []any{uid}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, filter.To)
+	}
+	if filter.Operation != "" {
+		conds = append(conds, "operation_type = ?")
+		args = append(args, filter.Operation)
+	}
+	if filter.Direction != "" {
+		conds = append(conds, "direction = ?")
+		args = append(args, filter.Direction)
+	}
+	if filter.ServiceId != 0 {
+		conds = append(conds, "service_id = ?")
+		args = append(args, filter.ServiceId)
+	}
+	if filter.OrderId != 0 {
+		conds = append(conds, "order_id = ?")
+		args = append(args, filter.OrderId)
+	}
+	if filter.CounterpartyId != 0 {
+		conds = append(conds, "counterparty_id = ?")
+		args = append(args, filter.CounterpartyId)
+	}
+	if filter.MinAmount != nil {
+		conds = append(conds, "amount >= ?")
+		args = append(args, *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		conds = append(conds, "amount <= ?")
+		args = append(args, *filter.MaxAmount)
+	}
+	return strings.Join(conds, " and "), args
+}
+
+func (d *db) GetUserReport(ctx context.Context, uid, rowOffest, pageSize uint32, sortBy, sortDirection string, filter *cashaccount.UserReportFilter, cursor *cashaccount.UserReportCursor) ([]*cashaccount.UserReportRow, error) {
 	res := make([]*cashaccount.UserReportRow, 0)
-	var statement string
 	if rowOffest == 0 && pageSize == 0 {
 		pageSize = 1000
 	}
-	if sortBy == "" {
-		statement = `select amount, description, created_at from user_report where service_user_id = ? limit ?, ?;`
-	} else {
-		statement = fmt.Sprintf(`select amount, description, created_at from user_report where service_user_id = ? order by %s %s limit ?, ?;`, sortBy, sortDirection)
+
+	// the rows are ordered by the sort column and then by id, so the order is total and a cursor is a stable position
+	var column string
+	switch sortBy {
+	case "created_at":
+		column = "created_at"
+	case "amount":
+		column = "amount"
+	}
+	direction, compare := "asc", ">"
+	if column != "" && sortDirection == "desc" {
+		direction, compare = "desc", "<"
+	}
+
+	where, args := userReportWhere(uid, filter)
+	if cursor != nil {
+		switch column {
+		case "":
+			where += ` and id ` + compare + ` ?`
+			args = append(args, cursor.ID)
+		case "amount":
+			where += ` and (amount ` + compare + ` cast(? as decimal(15,2)) or amount = cast(? as decimal(15,2)) and id ` + compare + ` ?)`
+			args = append(args, cursor.Key, cursor.Key, cursor.ID)
+		default:
+			where += ` and (` + column + ` ` + compare + ` ? or ` + column + ` = ? and id ` + compare + ` ?)`
+			args = append(args, cursor.Key, cursor.Key, cursor.ID)
+		}
+		rowOffest = 0
+	}
+
+	statement := `select ` + userReportColumns + ` from user_report where ` + where + ` order by `
+	if column != "" {
+		statement += column + ` ` + direction + `, `
 	}
-	rows, err := d.Query(statement, uid, rowOffest, pageSize)
+	statement += `id ` + direction + ` limit ?, ?;`
+	args = append(args, rowOffest, pageSize)
+
+	rows, err := d.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		item := new(cashaccount.UserReportRow)
-		if err := rows.Scan(&item.Amount, &item.Description, &item.DateTime); err != nil {
+		item, err := scanUserReportRow(rows)
+		if err != nil {
 			return nil, err
 		}
 
 		res = append(res, item)
 	}
 
-	return res, nil
+	return res, rows.Err()
 }
 
-func (d *db) CreateReport(ctx context.Context, timeStart, timeEnd string) ([]*cashaccount.BookkeepingReportRow, error) {
-	res := make([]*cashaccount.BookkeepingReportRow, 0)
-	rows, err := d.Query(`select service_id, sum(amount) as sum from bookkeeping where created_at >= ? and created_at < ? group by service_id;`, timeStart, timeEnd)
+func (d *db) CountUserReport(ctx context.Context, uid uint32, filter *cashaccount.UserReportFilter) (uint32, error) {
+	where, args := userReportWhere(uid, filter)
+	var total uint32
+	err := d.QueryRowContext(ctx, `select count(*) from user_report where `+where+`;`, args...).Scan(&total)
+	return total, err
+}
+
+func (d *db) StreamUserReport(ctx context.Context, uid uint32, filter *cashaccount.UserReportFilter, fn func(*cashaccount.UserReportRow) error) error {
+	where, args := userReportWhere(uid, filter)
+	rows, err := d.QueryContext(ctx, `select `+userReportColumns+` from user_report where `+where+` order by created_at, id;`, args...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanUserReportRow(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (d *db) GetBalanceAt(ctx context.Context, uid uint32, t time.Time) (float32, error) {
+	var balance float32
+	var row *sql.Row
+	if t.IsZero() {
+		row = d.QueryRowContext(ctx, `select balance_after from user_report where service_user_id = ? order by created_at desc, id desc limit 1;`, uid)
+	} else {
+		row = d.QueryRowContext(ctx, `select balance_after from user_report where service_user_id = ? and created_at < ? order by created_at desc, id desc limit 1;`, uid, t)
+	}
+	err := row.Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+func (d *db) GetLastUserReport(ctx context.Context, uid uint32) (*cashaccount.UserReportRow, error) {
+	row := d.QueryRow(`select `+userReportColumns+` from user_report where service_user_id = ? order by id desc limit 1;`, uid)
+	item, err := scanUserReportRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	return item, nil
+}
+
+// granularityPeriods maps the granularity to the first day of the period of the date column, weeks start on Monday
+var granularityPeriods = map[cashaccount.Granularity]string{
+	cashaccount.GranularityDay:   `date(%[1]s)`,
+	cashaccount.GranularityWeek:  `date(date_sub(%[1]s, interval weekday(%[1]s) day))`,
+	cashaccount.GranularityMonth: `date(date_format(%[1]s, '%%Y-%%m-01'))`,
+}
+
+// GranularityPeriod returns the SQL expression of the first day of the period of the column,
+// ok is false for GranularityNone and unknown values
+func GranularityPeriod(granularity cashaccount.Granularity, column string) (string, bool) {
+	period, ok := granularityPeriods[granularity]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(period, column), true
+}
+
+func (d *db) CreateReport(ctx context.Context, timeStart, timeEnd string, granularity cashaccount.Granularity) ([]*cashaccount.BookkeepingReportRow, error) {
+	res := make([]*cashaccount.BookkeepingReportRow, 0)
+	if granularity == cashaccount.GranularityNone {
+		rows, err := d.QueryContext(ctx, `select service_id, sum(revenue) as sum from revenue_daily where day >= date(?) and day < date(?) group by service_id order by service_id;`, timeStart, timeEnd)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			item := new(cashaccount.BookkeepingReportRow)
+			if err := rows.Scan(&item.ServiceId, &item.Amount); err != nil {
+				return nil, err
+			}
+			res = append(res, item)
+		}
+		return res, rows.Err()
+	}
+
+	period, ok := GranularityPeriod(granularity, "day")
+	if !ok {
+		return nil, apperror.ErrBadRequest
+	}
+	rows, err := d.QueryContext(ctx, `select `+period+` as period, service_id, sum(revenue) as sum from revenue_daily
+		where day >= date(?) and day < date(?) group by period, service_id order by period, service_id;`, timeStart, timeEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		item := new(cashaccount.BookkeepingReportRow)
-		if err := rows.Scan(&item.ServiceId, &item.Amount); err != nil {
+		if err := rows.Scan(&item.Period, &item.ServiceId, &item.Amount); err != nil {
 			return nil, err
 		}
 		res = append(res, item)
 	}
-	return res, nil
+	return res, rows.Err()
 }
 
-func (d *db) SaveReport(ctx context.Context, hash, path string) error {
-	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
-		_, err := tx.Exec(`delete from bookkeeping_report;`)
-		_, err = tx.Exec(`insert into bookkeeping_report (hash_string, path_to_file) values (?, ?);`, hash, path)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+func (d *db) SaveReport(ctx context.Context, report *cashaccount.BookkeepingReport) error {
+	r, err := d.ExecContext(ctx, `insert into bookkeeping_report (report_key, hash_string, path_to_file, period_start, period_end, granularity, format, created_by) values (?, ?, ?, ?, ?, ?, ?, ?);`,
+		report.Key, report.Hash, report.Path, report.PeriodStart, report.PeriodEnd, report.Granularity, report.Format, report.CreatedBy)
 	if err != nil {
-		d.logger.Errorf("Saving bookkeeping report failed %s. Hash: %s, path: %s", err, hash, path)
-	} else {
-		d.logger.Infof("Saved bookkeeping report. Hash: %s, path: %s", hash, path)
+		d.logger.Errorf("Saving bookkeeping report failed %s. Key: %s, path: %s", err, report.Key, report.Path)
+		return err
 	}
-	return err
+	d.logger.Infof("Saved bookkeeping report. Key: %s, path: %s", report.Key, report.Path)
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	report.ID = uint32(id)
+	return d.QueryRowContext(ctx, `select created_at from bookkeeping_report where id = ?;`, report.ID).Scan(&report.CreatedAt)
+}
+
+const bookkeepingReportColumns = `id, report_key, hash_string, path_to_file, period_start, period_end, granularity, format, created_by, created_at`
+
+func scanBookkeepingReport(row interface{ Scan(...any) error }) (*cashaccount.BookkeepingReport, error) {
+	report := new(cashaccount.BookkeepingReport)
+	err := row.Scan(&report.ID, &report.Key, &report.Hash, &report.Path, &report.PeriodStart, &report.PeriodEnd, &report.Granularity, &report.Format, &report.CreatedBy, &report.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
 }
 
-func (d *db) GetReport(ctx context.Context, hash string) (string, error) {
-	var path string
-	r := d.QueryRow(`select path_to_file from bookkeeping_report where hash_string = ?`, hash)
-	if err := r.Scan(&path); err != nil {
-		return "", err
+func (d *db) GetReport(ctx context.Context, key string) (*cashaccount.BookkeepingReport, error) {
+	r := d.QueryRowContext(ctx, `select `+bookkeepingReportColumns+` from bookkeeping_report where report_key = ? or hash_string = ? order by id desc limit 1;`, key, key)
+	report, err := scanBookkeepingReport(r)
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
-	if path == "" {
-		return "", apperror.ErrNotFound
+	return report, nil
+}
+
+func (d *db) ListReports(ctx context.Context) ([]*cashaccount.BookkeepingReport, error) {
+	rows, err := d.QueryContext(ctx, `select `+bookkeepingReportColumns+` from bookkeeping_report order by created_at desc, id desc;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*cashaccount.BookkeepingReport, 0)
+	for rows.Next() {
+		report, err := scanBookkeepingReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, report)
 	}
-	return path, nil
+	return res, rows.Err()
 }
 
 func NewStorage(database *sql.DB, logger *logging.Logger) cashaccount.Storage {
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AddRevenue writes the accepted money to the bookkeeping and adds it to the daily revenue of the service.
+// The day is taken from the created_at of the bookkeeping row, so the rollup matches the raw rows
+func AddRevenue(tx *sql.Tx, userId, serviceId uint32, amount float32) error {
+	r, err := tx.Exec(`insert into bookkeeping (service_user_id, service_id, amount) values (?, ?, ?)`, userId, serviceId, amount)
+	if err != nil {
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return addToRollup(tx, id, 1)
+}
+
+// AddAdjustment writes the correction of the closed period to the bookkeeping of the current day.
+// The amount may be negative, the adjustment is not counted as an order
+func AddAdjustment(tx *sql.Tx, serviceId uint32, amount float32, period, reason, createdBy string) (int64, error) {
+	r, err := tx.Exec(`insert into bookkeeping (service_id, amount, adjusts_period, reason, created_by) values (?, ?, ?, ?, ?)`,
+		serviceId, amount, period, reason, createdBy)
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, addToRollup(tx, id, 0)
+}
+
+func addToRollup(tx *sql.Tx, bookkeepingId int64, orders int) error {
+	_, err := tx.Exec(`insert into revenue_daily (day, service_id, revenue, orders)
+		select date(created_at), service_id, amount, ? from bookkeeping where id = ?
+		on duplicate key update revenue = revenue + values(revenue), orders = orders + values(orders);`, orders, bookkeepingId)
+	return err
+}
+
+// RebuildRevenueDaily recomputes the daily revenue from the bookkeeping rows created in [from, to).
+// Zero from or to leaves the range open on that side. The days of the closed periods are frozen
+// and skipped. It returns the number of rollup rows written
+func RebuildRevenueDaily(ctx context.Context, database *sql.DB, from, to time.Time) (int64, error) {
+	days := ` where not exists (select 1 from closed_period c where day >= c.period_start and day < c.period_end)`
+	rows := ` where not exists (select 1 from closed_period c where created_at >= c.period_start and created_at < c.period_end)`
+	args := make([]any, 0, 2)
+	if !from.IsZero() {
+		days += ` and day >= date(?)`
+		rows += ` and created_at >= date(?)`
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		days += ` and day < date(?)`
+		rows += ` and created_at < date(?)`
+		args = append(args, to)
+	}
+
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `delete from revenue_daily`+days+`;`, args...); err != nil {
+		return 0, err
+	}
+	// insert ... select locks the bookkeeping rows it reads, so AcceptRevenue waits for the
+	// rebuild of the range instead of adding to a rollup which is being replaced
+	r, err := tx.ExecContext(ctx, `insert into revenue_daily (day, service_id, revenue, orders)
+		select date(created_at) as day, service_id, sum(amount), sum(adjusts_period is null) from bookkeeping`+rows+`
+		group by day, service_id;`, args...)
+	if err != nil {
+		return 0, err
+	}
+	written, err := r.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return written, tx.Commit()
+}
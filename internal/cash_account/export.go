@@ -0,0 +1,233 @@
+package cashaccount
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"user-balance-service/pkg/pdf"
+	"user-balance-service/pkg/xlsx"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV  ExportFormat = "csv"
+	ExportXLSX ExportFormat = "xlsx"
+	ExportPDF  ExportFormat = "pdf"
+)
+
+func (f ExportFormat) Valid() bool {
+	return f == ExportCSV || f == ExportXLSX || f == ExportPDF
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportXLSX:
+		return xlsx.ContentType
+	case ExportPDF:
+		return pdf.ContentType
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Statement describes the exported period, the balances include not expired bonuses
+type Statement struct {
+	UserId         uint32
+	From           time.Time
+	To             time.Time
+	OpeningBalance float32
+	ClosingBalance float32
+	// Totals are the signed sums of the exported rows by operation type
+	Totals map[OperationType]float32
+	Locale Locale
+}
+
+func (s *Statement) add(row *UserReportRow) {
+	if row.Type != "" {
+		s.Totals[row.Type] += signedAmount(row)
+	}
+}
+
+// totals returns the operation types of the statement in the order of OperationTypes
+func (s *Statement) totals() []OperationType {
+	res := make([]OperationType, 0, len(s.Totals))
+	for _, t := range OperationTypes {
+		if _, ok := s.Totals[t]; ok {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// statementWriter writes the rows of the statement as they are read from the database
+type statementWriter interface {
+	Row(row *UserReportRow) error
+	// Close writes the closing balance, it does not close the underlying writer
+	Close(s *Statement) error
+}
+
+func newStatementWriter(format ExportFormat, w io.Writer, s *Statement) (statementWriter, error) {
+	switch format {
+	case ExportXLSX:
+		return newXlsxStatement(w, s)
+	case ExportPDF:
+		return newPdfStatement(w, s)
+	}
+	return newCsvStatement(w, s)
+}
+
+// signedAmount is negative for debits
+func signedAmount(row *UserReportRow) float32 {
+	if row.Direction == Debit {
+		return -row.Amount
+	}
+	return row.Amount
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(cursorTimeFormat)
+}
+
+func columns(locale Locale) []interface{} {
+	res := make([]interface{}, 0, 6)
+	for _, key := range []string{"column_date", "column_operation", "column_direction", "column_amount", "column_description", "column_balance"} {
+		res = append(res, label(locale, key))
+	}
+	return res
+}
+
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func newCsvStatement(w io.Writer, s *Statement) (*csvStatement, error) {
+	c := &csvStatement{w: csv.NewWriter(w)}
+	header := make([]string, 0)
+	for _, column := range columns(s.Locale) {
+		header = append(header, column.(string))
+	}
+	if err := c.w.Write(header); err != nil {
+		return nil, err
+	}
+	err := c.w.Write([]string{formatBound(s.From), "", "", "", label(s.Locale, "opening_balance"), fmt.Sprintf("%.2f", s.OpeningBalance)})
+	return c, err
+}
+
+func (c *csvStatement) Row(row *UserReportRow) error {
+	return c.w.Write([]string{
+		row.DateTime.Format(cursorTimeFormat),
+		string(row.Type),
+		string(row.Direction),
+		fmt.Sprintf("%.2f", signedAmount(row)),
+		row.Description,
+		fmt.Sprintf("%.2f", row.BalanceAfter),
+	})
+}
+
+func (c *csvStatement) Close(s *Statement) error {
+	for _, t := range s.totals() {
+		err := c.w.Write([]string{"", string(t), "", fmt.Sprintf("%.2f", s.Totals[t]), label(s.Locale, "total"), ""})
+		if err != nil {
+			return err
+		}
+	}
+	err := c.w.Write([]string{formatBound(s.To), "", "", "", label(s.Locale, "closing_balance"), fmt.Sprintf("%.2f", s.ClosingBalance)})
+	if err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type xlsxStatement struct {
+	w *xlsx.Writer
+}
+
+func newXlsxStatement(w io.Writer, s *Statement) (*xlsxStatement, error) {
+	xw, err := xlsx.NewWriter(w, label(s.Locale, "statement_sheet"))
+	if err != nil {
+		return nil, err
+	}
+	if err := xw.WriteRow(columns(s.Locale)...); err != nil {
+		return nil, err
+	}
+	err = xw.WriteRow(formatBound(s.From), "", "", "", label(s.Locale, "opening_balance"), s.OpeningBalance)
+	return &xlsxStatement{xw}, err
+}
+
+func (x *xlsxStatement) Row(row *UserReportRow) error {
+	return x.w.WriteRow(row.DateTime, string(row.Type), string(row.Direction), signedAmount(row), row.Description, row.BalanceAfter)
+}
+
+func (x *xlsxStatement) Close(s *Statement) error {
+	for _, t := range s.totals() {
+		if err := x.w.WriteRow("", string(t), "", s.Totals[t], label(s.Locale, "total"), ""); err != nil {
+			return err
+		}
+	}
+	if err := x.w.WriteRow(formatBound(s.To), "", "", "", label(s.Locale, "closing_balance"), s.ClosingBalance); err != nil {
+		return err
+	}
+	return x.w.Close()
+}
+
+// pdfRow aligns the columns of the statement, the description takes the rest of the line
+const pdfRow = "%-19s %-13s %12s %12s  %s"
+
+type pdfStatement struct {
+	w *pdf.Writer
+}
+
+func newPdfStatement(w io.Writer, s *Statement) (*pdfStatement, error) {
+	pw, err := pdf.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{
+		fmt.Sprintf("%s %d", label(s.Locale, "statement_title"), s.UserId),
+		fmt.Sprintf("%s: %s - %s", label(s.Locale, "period"), formatBound(s.From), formatBound(s.To)),
+		fmt.Sprintf("%s: %.2f", label(s.Locale, "opening_balance"), s.OpeningBalance),
+		"",
+		fmt.Sprintf(pdfRow, label(s.Locale, "column_date"), label(s.Locale, "column_operation"),
+			label(s.Locale, "column_amount"), label(s.Locale, "column_balance"), label(s.Locale, "column_description")),
+		strings.Repeat("-", pdf.LineWidth),
+	}
+	for _, line := range lines {
+		if err := pw.Line(line); err != nil {
+			return nil, err
+		}
+	}
+	return &pdfStatement{pw}, nil
+}
+
+func (p *pdfStatement) Row(row *UserReportRow) error {
+	line := fmt.Sprintf(pdfRow, row.DateTime.Format(cursorTimeFormat), row.Type,
+		fmt.Sprintf("%.2f", signedAmount(row)), fmt.Sprintf("%.2f", row.BalanceAfter), row.Description)
+	if runes := []rune(line); len(runes) > pdf.LineWidth {
+		line = string(runes[:pdf.LineWidth])
+	}
+	return p.w.Line(line)
+}
+
+func (p *pdfStatement) Close(s *Statement) error {
+	lines := []string{strings.Repeat("-", pdf.LineWidth)}
+	if len(s.Totals) > 0 {
+		lines = append(lines, label(s.Locale, "totals")+":")
+		for _, t := range s.totals() {
+			lines = append(lines, fmt.Sprintf("  %-13s %12.2f", t, s.Totals[t]))
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf("%s: %.2f", label(s.Locale, "closing_balance"), s.ClosingBalance))
+	for _, line := range lines {
+		if err := p.w.Line(line); err != nil {
+			return err
+		}
+	}
+	return p.w.Close()
+}
@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 	"user-balance-service/internal/apperror"
-	"user-balance-service/internal/config"
 	"user-balance-service/internal/handlers"
 	"user-balance-service/internal/middleware"
 	"user-balance-service/pkg/logging"
@@ -15,30 +15,42 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// streamPingInterval keeps idle streams alive behind proxies
+const streamPingInterval = 15 * time.Second
+
 type handler struct {
 	service *Service
+	broker  *Broker
+	links   *LinkSigner
 	logger  *logging.Logger
 	cache   map[string]string
 }
 
-func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+func NewHandler(service *Service, broker *Broker, links *LinkSigner, logger *logging.Logger) handlers.Handler {
 	return &handler{
 		service: service,
+		broker:  broker,
+		links:   links,
 		logger:  logger,
 		cache:   make(map[string]string),
 	}
 }
 
-func (h *handler) Register(router *httprouter.Router) {
+func (h *handler) Register(router *handlers.Router) {
 	router.HandlerFunc(http.MethodPost, "/api/users/accrual/", middleware.Middleware(h.Accrual))
+	router.HandlerFunc(http.MethodPost, "/api/users/bonus/accrual/", middleware.Middleware(h.BonusAccrual))
 	router.HandlerFunc(http.MethodPost, "/api/users/withdraw/", middleware.Middleware(h.Withdraw))
 	router.HandlerFunc(http.MethodPost, "/api/users/reserve/", middleware.Middleware(h.Reserve))
 	router.HandlerFunc(http.MethodPost, "/api/users/accept/", middleware.Middleware(h.AcceptTransfer))
+	router.HandlerFunc(http.MethodPost, "/api/users/cancel/", middleware.Middleware(h.CancelReservation))
 	router.HandlerFunc(http.MethodPost, "/api/users/transaction/", middleware.Middleware(h.UsersTransfer))
 	router.HandlerFunc(http.MethodGet, "/api/users/balance/:id", middleware.Middleware(h.GetUserBalance))
-	router.HandlerFunc(http.MethodPost, "/api/report/create/", middleware.Middleware(h.CreateReport))
+	router.Dispatch(http.MethodGet, "/api/users/:id/stream", middleware.Middleware(h.StreamUserBalance))
+	router.HandlerFunc(http.MethodGet, "/api/report/", middleware.Middleware(h.ListReports))
 	router.HandlerFunc(http.MethodGet, "/api/report/:hash", middleware.Middleware(h.GetReport))
+	router.HandlerFunc(http.MethodGet, "/api/report/:hash/verify", middleware.Middleware(h.VerifyReport))
 	router.HandlerFunc(http.MethodGet, "/api/users/report/", middleware.Middleware(h.GetUserReport))
+	router.HandlerFunc(http.MethodGet, "/api/users/report/export", middleware.Middleware(h.ExportUserReport))
 }
 
 func (h *handler) Accrual(w http.ResponseWriter, r *http.Request) error {
@@ -56,6 +68,21 @@ func (h *handler) Accrual(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func (h *handler) BonusAccrual(w http.ResponseWriter, r *http.Request) error {
+	var data BonusAmount
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	err = h.service.TopUpBonus(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (h *handler) Withdraw(w http.ResponseWriter, r *http.Request) error {
 	var data UserAmount
 	err := json.NewDecoder(r.Body).Decode(&data)
@@ -91,6 +118,70 @@ func (h *handler) GetUserBalance(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// StreamUserBalance pushes the balance of the user as Server-Sent Events until the client disconnects
+func (h *handler) StreamUserBalance(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	numUserId, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+	userId := uint32(numUserId)
+	locale := LocaleFromRequest(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("Streaming is not supported by the response writer")
+	}
+
+	// subscribe before reading the balance so no change is lost in between
+	updates, unsubscribe := h.broker.Subscribe(userId)
+	defer unsubscribe()
+
+	update, err := h.service.GetBalanceUpdate(r.Context(), userId, locale)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	for {
+		if update != nil {
+			payload, err := json.Marshal(update)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: balance\ndata: %s\n\n", payload); err != nil {
+				return nil
+			}
+			flusher.Flush()
+			update = nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-updates:
+			update, err = h.service.GetBalanceUpdate(r.Context(), userId, locale)
+			if err != nil {
+				// the status is already sent, so the client just reconnects
+				h.logger.Errorf("Error %s in balance stream of user %d", err, userId)
+				return nil
+			}
+		}
+	}
+}
+
 func (h *handler) Reserve(w http.ResponseWriter, r *http.Request) error {
 	var details ReserveDetails
 	err := json.NewDecoder(r.Body).Decode(&details)
@@ -121,6 +212,21 @@ func (h *handler) AcceptTransfer(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func (h *handler) CancelReservation(w http.ResponseWriter, r *http.Request) error {
+	var details ReserveDetails
+	err := json.NewDecoder(r.Body).Decode(&details)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	err = h.service.CancelReservation(context.Background(), &details)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (h *handler) UsersTransfer(w http.ResponseWriter, r *http.Request) error {
 	var transferData MoneyTransferDetails
 	err := json.NewDecoder(r.Body).Decode(&transferData)
@@ -142,6 +248,7 @@ func (h *handler) GetUserReport(w http.ResponseWriter, r *http.Request) error {
 	urlPageSize := r.URL.Query().Get("pageSize")
 	sortBy := r.URL.Query().Get("sortBy")
 	sortDirection := r.URL.Query().Get("sortDirection")
+	cursor := r.URL.Query().Get("cursor")
 	if urlId == "" {
 		return apperror.ErrBadRequest
 	}
@@ -150,7 +257,11 @@ func (h *handler) GetUserReport(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	if urlPageNum == "" && urlPageSize != "" || urlPageNum != "" && urlPageSize == "" {
+	// with a cursor the page size may be set alone, the page number is not used
+	if urlPageNum == "" && urlPageSize != "" && cursor == "" || urlPageNum != "" && urlPageSize == "" {
+		return apperror.ErrBadRequest
+	}
+	if urlPageNum != "" && cursor != "" {
 		return apperror.ErrBadRequest
 	}
 
@@ -177,50 +288,187 @@ func (h *handler) GetUserReport(w http.ResponseWriter, r *http.Request) error {
 		return apperror.ErrBadRequest
 	}
 
-	urr, err := h.service.GetUserReport(context.Background(), uint32(uid), uint32(pageNum), uint32(pageSize), sortBy, sortDirection)
+	filter, err := parseUserReportFilter(r)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	urr, err := h.service.GetUserReport(context.Background(), uint32(uid), uint32(pageNum), uint32(pageSize), cursor, sortBy, sortDirection, filter, LocaleFromRequest(r))
 	if err != nil {
 		return err
 	}
 
-	json.NewEncoder(w).Encode(urr)
+	// the page is an array of rows like before filters and cursors were added
+	w.Header().Set("X-Total-Count", strconv.FormatUint(uint64(urr.Total), 10))
+	if urr.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", urr.NextCursor)
+	}
+	json.NewEncoder(w).Encode(urr.Rows)
 
 	return nil
 }
 
-func (h *handler) CreateReport(w http.ResponseWriter, r *http.Request) error {
-	startTime := r.URL.Query().Get("startTime")
-	if startTime == "" {
+// attachmentWriter sets the headers of the file on the first write, so an error
+// which happens before any data is written still gets its own status and body
+type attachmentWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (a *attachmentWriter) Write(p []byte) (int, error) {
+	if !a.started {
+		a.started = true
+		a.w.Header().Set("Content-Type", a.contentType)
+		a.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, a.filename))
+	}
+	return a.w.Write(p)
+}
+
+func (h *handler) ExportUserReport(w http.ResponseWriter, r *http.Request) error {
+	uid, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || uid <= 0 {
 		return apperror.ErrBadRequest
 	}
-	hash, err := h.service.CreateBookkeepingReport(context.Background(), startTime)
+	format := ExportFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = ExportCSV
+	}
+	if !format.Valid() {
+		return apperror.ErrBadRequest
+	}
+	filter, err := parseUserReportFilter(r)
 	if err != nil {
-		return err
+		return apperror.ErrBadRequest
+	}
+
+	out := &attachmentWriter{
+		w:           w,
+		contentType: format.ContentType(),
+		filename:    fmt.Sprintf("statement-%d.%s", uid, format),
+	}
+	err = h.service.ExportUserReport(r.Context(), uint32(uid), format, filter, LocaleFromRequest(r), out)
+	if err != nil && out.started {
+		// the status is already sent, the client gets a truncated file
+		h.logger.Errorf("Error %s in export of the report of user %d", err, uid)
+		return nil
 	}
+	return err
+}
+
+// parseTime accepts a date or a date with time in RFC 3339
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
 
-	cfg := config.GetConfig()
-	resp := &ReportLinkResponse{
-		Link: fmt.Sprintf("%s:%s/api/report/%s", cfg.Listen.BindIp, cfg.Listen.Port, hash),
+func parseUserReportFilter(r *http.Request) (*UserReportFilter, error) {
+	query := r.URL.Query()
+	filter := &UserReportFilter{
+		Operation: OperationType(query.Get("operationType")),
+		Direction: Direction(query.Get("direction")),
+	}
+
+	var err error
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = parseTime(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = parseTime(v); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := map[string]*uint32{
+		"serviceId":      &filter.ServiceId,
+		"orderId":        &filter.OrderId,
+		"counterpartyId": &filter.CounterpartyId,
+	}
+	for name, dst := range ids {
+		if v := query.Get(name); v != "" {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || id == 0 {
+				return nil, apperror.ErrBadRequest
+			}
+			*dst = uint32(id)
+		}
 	}
 
-	w.WriteHeader(201)
+	amounts := map[string]**float32{
+		"minAmount": &filter.MinAmount,
+		"maxAmount": &filter.MaxAmount,
+	}
+	for name, dst := range amounts {
+		if v := query.Get(name); v != "" {
+			amount, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return nil, err
+			}
+			value := float32(amount)
+			*dst = &value
+		}
+	}
+
+	return filter, nil
+}
+
+func (h *handler) ListReports(w http.ResponseWriter, r *http.Request) error {
+	if !h.links.Authorized(r) {
+		return apperror.ErrForbidden
+	}
+	reports, err := h.service.ListBookkeepingReports(context.Background())
+	if err != nil {
+		return err
+	}
+
+	resp := make([]*ReportLinkResponse, 0, len(reports))
+	for _, report := range reports {
+		link, expiresAt := h.links.Link(report.Key)
+		resp = append(resp, &ReportLinkResponse{Link: link, ExpiresAt: expiresAt, Report: report})
+	}
 	json.NewEncoder(w).Encode(resp)
 	return nil
 }
 
 func (h *handler) GetReport(w http.ResponseWriter, r *http.Request) error {
 	params := httprouter.ParamsFromContext(r.Context())
-	hash := params.ByName("hash")
-	if hash == "" {
+	key := params.ByName("hash")
+	if key == "" {
+		return apperror.ErrBadRequest
+	}
+	if err := h.links.Verify(key, r.URL.Query().Get("expires"), r.URL.Query().Get("signature")); err != nil {
+		return err
+	}
+
+	report, content, err := h.service.OpenBookkeepingReport(context.Background(), key)
+	if err != nil {
+		return err
+	}
+
+	renderer := NewReportRenderer(report.Format, 0)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=report-%s.%s", report.Key, renderer.Extension()))
+	w.Header().Set("Content-Type", renderer.ContentType())
+	http.ServeContent(w, r, "", report.CreatedAt, content)
+	return nil
+}
+
+func (h *handler) VerifyReport(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := params.ByName("hash")
+	if key == "" {
 		return apperror.ErrBadRequest
 	}
 
-	path, err := h.service.GetBookkeepingReport(context.Background(), hash)
+	res, err := h.service.VerifyBookkeepingReport(context.Background(), key)
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename=report.csv")
-	w.Header().Set("Content-Type", "application/octet-stream")
-	http.ServeFile(w, r, path)
+	json.NewEncoder(w).Encode(res)
 	return nil
 }
@@ -0,0 +1,91 @@
+package cashaccount
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+)
+
+// devLinkSecret was published in the example configuration, links signed with it can be forged by anyone
+const devLinkSecret = "dev-report-link-secret"
+
+// LinkSigner builds download links of bookkeeping reports which expire after ttl.
+// The link is signed with an HMAC of the report key and the expiry time.
+// Links are given only to the clients with the token, anyone with a link may download the report
+type LinkSigner struct {
+	baseUrl string
+	secret  []byte
+	token   []byte
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func (l *LinkSigner) sign(key string, expires int64) string {
+	h := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(h, "%s\n%d", key, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Link returns the signed download link of the report and the time it expires
+func (l *LinkSigner) Link(key string) (string, time.Time) {
+	expiresAt := l.now().Add(l.ttl).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", l.sign(key, expiresAt.Unix()))
+	return fmt.Sprintf("%s/api/report/%s?%s", l.baseUrl, url.PathEscape(key), query.Encode()), expiresAt
+}
+
+// Authorized reports whether the request has the token in the Authorization: Bearer header
+func (l *LinkSigner) Authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), l.token) == 1
+}
+
+// Verify checks the expires and signature parameters of the link
+func (l *LinkSigner) Verify(key, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || signature == "" {
+		return apperror.ErrForbidden
+	}
+	if !hmac.Equal([]byte(signature), []byte(l.sign(key, expiresAt))) {
+		return apperror.ErrForbidden
+	}
+	if !l.now().Before(time.Unix(expiresAt, 0)) {
+		return apperror.ErrForbidden
+	}
+	return nil
+}
+
+// NewLinkSigner creates links starting with the public baseUrl of the service
+func NewLinkSigner(baseUrl, secret, token string, ttl time.Duration, now func() time.Time) (*LinkSigner, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("Secret of report links is not set")
+	}
+	if secret == devLinkSecret {
+		return nil, fmt.Errorf("Secret of report links is the published development secret, set another one")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("Token of report links is not set")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("Lifetime of report links must be positive, got %s", ttl)
+	}
+	if _, err := url.Parse(baseUrl); err != nil {
+		return nil, err
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &LinkSigner{baseUrl: strings.TrimSuffix(baseUrl, "/"), secret: []byte(secret), token: []byte(token), ttl: ttl, now: now}, nil
+}
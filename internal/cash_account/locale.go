@@ -0,0 +1,152 @@
+package cashaccount
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type Locale string
+
+const (
+	English Locale = "en"
+	Russian Locale = "ru"
+
+	DefaultLocale = English
+)
+
+// messages are the description templates of the operations per locale.
+// Transfers have separate templates for the sender and the receiver
+var messages = map[Locale]map[string]string{
+	English: {
+		"top_up":          `Account replenished`,
+		"payment":         `Account replenished by the payment {{.Reference}}`,
+		"promo":           `Account replenished by the promo code {{.Reference}}`,
+		"bonus_top_up":    `Bonus account replenished, bonuses expire at {{.Reference}}`,
+		"withdraw":        `Debiting money from an account`,
+		"transfer_debit":  `Transferring money to a user {{.CounterpartyId}}`,
+		"transfer_credit": `Receiving money from the user {{.CounterpartyId}}`,
+		"reserve":         `The money {{printf "%f" .Amount}} was reserved for the order {{.OrderId}} and the service {{.ServiceId}}`,
+		"unreserve":       `The money {{printf "%f" .Amount}} was unreserved for the order {{.OrderId}} and the service {{.ServiceId}}`,
+		"accept":          `The money {{printf "%f" .Amount}} was accepted for the order {{.OrderId}} and the service {{.ServiceId}}`,
+		"payout_hold":     `The money {{printf "%f" .Amount}} was held for the payout {{.Reference}}`,
+		"payout":          `The money {{printf "%f" .Amount}} was paid out by the payout {{.Reference}}`,
+		"payout_return":   `The money {{printf "%f" .Amount}} was returned after the failed payout {{.Reference}}`,
+	},
+	Russian: {
+		"top_up":          `Пополнение счета`,
+		"payment":         `Пополнение счета платежом {{.Reference}}`,
+		"promo":           `Пополнение счета по промокоду {{.Reference}}`,
+		"bonus_top_up":    `Начисление бонусов, бонусы действуют до {{.Reference}}`,
+		"withdraw":        `Списание средств со счета`,
+		"transfer_debit":  `Перевод пользователю {{.CounterpartyId}}`,
+		"transfer_credit": `Перевод от пользователя {{.CounterpartyId}}`,
+		"reserve":         `Резервирование {{printf "%.2f" .Amount}} для заказа {{.OrderId}} услуги {{.ServiceId}}`,
+		"unreserve":       `Отмена резервирования {{printf "%.2f" .Amount}} для заказа {{.OrderId}} услуги {{.ServiceId}}`,
+		"accept":          `Оплата {{printf "%.2f" .Amount}} за заказ {{.OrderId}} услуги {{.ServiceId}}`,
+		"payout_hold":     `Резервирование {{printf "%.2f" .Amount}} для выплаты {{.Reference}}`,
+		"payout":          `Выплата {{.Reference}} на сумму {{printf "%.2f" .Amount}} выполнена`,
+		"payout_return":   `Возврат {{printf "%.2f" .Amount}} после неудачной выплаты {{.Reference}}`,
+	},
+}
+
+// labels are the texts of the exported statements
+var labels = map[Locale]map[string]string{
+	English: {
+		"statement_title":    "Statement of the user",
+		"statement_sheet":    "Statement",
+		"period":             "Period",
+		"opening_balance":    "Opening balance",
+		"closing_balance":    "Closing balance",
+		"column_date":        "Date",
+		"column_operation":   "Operation",
+		"column_direction":   "Direction",
+		"column_amount":      "Amount",
+		"column_description": "Description",
+		"column_balance":     "Balance",
+		"total":              "Total",
+		"totals":             "Totals by operation",
+	},
+	Russian: {
+		"statement_title":    "Выписка по счету пользователя",
+		"statement_sheet":    "Выписка",
+		"period":             "Период",
+		"opening_balance":    "Входящий остаток",
+		"closing_balance":    "Исходящий остаток",
+		"column_date":        "Дата",
+		"column_operation":   "Операция",
+		"column_direction":   "Направление",
+		"column_amount":      "Сумма",
+		"column_description": "Описание",
+		"column_balance":     "Остаток",
+		"total":              "Итого",
+		"totals":             "Итоги по операциям",
+	},
+}
+
+func label(locale Locale, key string) string {
+	if text, ok := labels[locale][key]; ok {
+		return text
+	}
+	return labels[DefaultLocale][key]
+}
+
+var templates = parseMessages()
+
+func parseMessages() map[Locale]map[string]*template.Template {
+	res := make(map[Locale]map[string]*template.Template)
+	for locale, m := range messages {
+		res[locale] = make(map[string]*template.Template)
+		for key, text := range m {
+			res[locale][key] = template.Must(template.New(key).Parse(text))
+		}
+	}
+	return res
+}
+
+// ParseLocale returns the supported locale of the language tag like "ru-RU"
+func ParseLocale(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	_, ok := messages[Locale(tag)]
+	return Locale(tag), ok
+}
+
+// LocaleFromRequest selects the locale by the lang query parameter or else by the Accept-Language header
+func LocaleFromRequest(r *http.Request) Locale {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		if locale, ok := ParseLocale(lang); ok {
+			return locale
+		}
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	tags := make([]weighted, 0)
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		w := weighted{tag: fields[0], q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if q, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					w.q = q
+				}
+			}
+		}
+		tags = append(tags, w)
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, w := range tags {
+		if locale, ok := ParseLocale(w.tag); ok && w.q > 0 {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
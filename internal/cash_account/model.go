@@ -7,6 +7,30 @@ type UserAmount struct {
 	Amount float32 `json:"amount"`
 }
 
+type BonusAmount struct {
+	ID        uint32    `json:"id"`
+	Amount    float32   `json:"amount"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type UserBalance struct {
+	ID     uint32  `json:"id"`
+	Amount float32 `json:"amount"`
+	Bonus  float32 `json:"bonus"`
+}
+
+// SpendPriority defines which balance is charged first when money is reserved
+type SpendPriority string
+
+const (
+	BonusFirst SpendPriority = "bonus_first"
+	MainFirst  SpendPriority = "main_first"
+)
+
+func (p SpendPriority) Valid() bool {
+	return p == BonusFirst || p == MainFirst
+}
+
 type MoneyTransferDetails struct {
 	FromId uint32  `json:"from_id"`
 	ToId   uint32  `json:"to_id"`
@@ -25,16 +49,77 @@ type TextResponse struct {
 }
 
 type ReportLinkResponse struct {
-	Link string `json:"link"`
+	Link      string             `json:"link"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	Report    *BookkeepingReport `json:"report,omitempty"`
+}
+
+// BookkeepingReport is a generated accounting report. Every report has its own file and key,
+// so links to the earlier reports stay valid
+type BookkeepingReport struct {
+	ID  uint32 `json:"id"`
+	Key string `json:"key"`
+	// Hash is the SHA-256 of the file content
+	Hash        string       `json:"hash"`
+	PeriodStart time.Time    `json:"period_start"`
+	PeriodEnd   time.Time    `json:"period_end"`
+	Granularity Granularity  `json:"granularity,omitempty"`
+	Format      ReportFormat `json:"format"`
+	CreatedBy   string       `json:"created_by"`
+	CreatedAt   time.Time    `json:"created_at"`
+	// Path is the key of the file in the file store
+	Path string `json:"-"`
+}
+
+// ReportVerification compares the stored hash of the report with the hash of its file,
+// ActualHash is empty when the file is missing
+type ReportVerification struct {
+	Key        string `json:"key"`
+	Hash       string `json:"hash"`
+	ActualHash string `json:"actual_hash"`
+	Valid      bool   `json:"valid"`
 }
 
 type BookkeepingReportRow struct {
+	// Period is the first day of the day, week or month, it is zero when the report has no granularity
+	Period    time.Time
 	ServiceId uint32
 	Amount    float32
 }
 
 type UserReportRow struct {
+	ID          uint32    `json:"id"`
 	Amount      float32   `json:"amount"`
 	Description string    `json:"description"`
 	DateTime    time.Time `json:"dateTime"`
+	Operation
+	// BalanceAfter is the main balance plus not expired bonuses after the operation
+	BalanceAfter float32 `json:"balance_after"`
+}
+
+// UserReportFilter narrows the user report, zero values do not filter
+type UserReportFilter struct {
+	From           time.Time
+	To             time.Time
+	Operation      OperationType
+	Direction      Direction
+	ServiceId      uint32
+	OrderId        uint32
+	CounterpartyId uint32
+	MinAmount      *float32
+	MaxAmount      *float32
+}
+
+type UserReport struct {
+	// Total is the number of rows matching the filter on all pages
+	Total uint32 `json:"total"`
+	// NextCursor is empty on the last page
+	NextCursor string           `json:"next_cursor"`
+	Rows       []*UserReportRow `json:"rows"`
+}
+
+// BalanceUpdate is pushed to the balance stream of the user
+type BalanceUpdate struct {
+	Balance       *UserBalance   `json:"balance"`
+	LastOperation *UserReportRow `json:"last_operation"`
 }
@@ -0,0 +1,88 @@
+package cashaccount
+
+import "strings"
+
+// OperationType is the kind of the operation in the history of the user
+type OperationType string
+
+const (
+	OperationTopUp        OperationType = "top_up"
+	OperationPayment      OperationType = "payment"
+	OperationPromo        OperationType = "promo"
+	OperationBonusTopUp   OperationType = "bonus_top_up"
+	OperationWithdraw     OperationType = "withdraw"
+	OperationTransfer     OperationType = "transfer"
+	OperationReserve      OperationType = "reserve"
+	OperationUnreserve    OperationType = "unreserve"
+	OperationAccept       OperationType = "accept"
+	OperationPayoutHold   OperationType = "payout_hold"
+	OperationPayout       OperationType = "payout"
+	OperationPayoutReturn OperationType = "payout_return"
+)
+
+var OperationTypes = []OperationType{
+	OperationTopUp, OperationPayment, OperationPromo, OperationBonusTopUp, OperationWithdraw, OperationTransfer,
+	OperationReserve, OperationUnreserve, OperationAccept, OperationPayoutHold, OperationPayout, OperationPayoutReturn,
+}
+
+func (t OperationType) Valid() bool {
+	for _, v := range OperationTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Direction tells how the operation changed the available balance of the user
+type Direction string
+
+const (
+	Credit Direction = "credit"
+	Debit  Direction = "debit"
+	// None is used for operations which settle money that was already debited, like accept
+	None Direction = "none"
+)
+
+func (d Direction) Valid() bool {
+	return d == Credit || d == Debit || d == None
+}
+
+// Operation is a typed record of the user history
+type Operation struct {
+	Type           OperationType `json:"operation_type"`
+	Direction      Direction     `json:"direction"`
+	CounterpartyId uint32        `json:"counterparty_id,omitempty"`
+	ServiceId      uint32        `json:"service_id,omitempty"`
+	OrderId        uint32        `json:"order_id,omitempty"`
+	// Reference is an id in another subsystem: payment, payout, promo code or bonus expiry time
+	Reference string `json:"reference,omitempty"`
+}
+
+func (o *Operation) messageKey() string {
+	if o.Type == OperationTransfer {
+		return string(o.Type) + "_" + string(o.Direction)
+	}
+	return string(o.Type)
+}
+
+// Describe renders the human readable description of the operation in the locale
+func (o *Operation) Describe(locale Locale, amount float32) string {
+	t, ok := templates[locale][o.messageKey()]
+	if !ok {
+		t, ok = templates[DefaultLocale][o.messageKey()]
+		if !ok {
+			return ""
+		}
+	}
+
+	var b strings.Builder
+	data := struct {
+		*Operation
+		Amount float32
+	}{o, amount}
+	if err := t.Execute(&b, data); err != nil {
+		return ""
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package cashaccount
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+)
+
+// Granularity splits the bookkeeping report of every service into periods
+type Granularity string
+
+const (
+	// GranularityNone sums the whole period of the report
+	GranularityNone  Granularity = ""
+	GranularityDay   Granularity = "day"
+	GranularityWeek  Granularity = "week"
+	GranularityMonth Granularity = "month"
+)
+
+func (g Granularity) Valid() bool {
+	switch g {
+	case GranularityNone, GranularityDay, GranularityWeek, GranularityMonth:
+		return true
+	}
+	return false
+}
+
+// maxReportDays limits the period of the bookkeeping report
+const maxReportDays = 5 * 366
+
+// BookkeepingReportRequest describes the period of the report. Period is a month (2006-01),
+// a quarter (2006-Q1) or a year (2006). Otherwise From and To (2006-01-02) set a custom range
+// including both days
+type BookkeepingReportRequest struct {
+	Period      string      `json:"period,omitempty"`
+	From        string      `json:"from,omitempty"`
+	To          string      `json:"to,omitempty"`
+	Granularity Granularity `json:"granularity,omitempty"`
+	// Format is csv when it is empty
+	Format  ReportFormat `json:"format,omitempty"`
+	Creator string       `json:"created_by,omitempty"`
+}
+
+// ReportPeriod is a validated period and layout of the bookkeeping report, End is exclusive
+type ReportPeriod struct {
+	Label       string
+	Start       time.Time
+	End         time.Time
+	Granularity Granularity
+	Format      ReportFormat
+}
+
+// Key is the same for the requests of the same report
+func (p *ReportPeriod) Key() string {
+	return fmt.Sprintf("%s_%s_%s_%s", p.Start.Format("20060102"), p.End.Format("20060102"), p.Granularity, p.Format)
+}
+
+// ParseReportPeriod validates the request and returns the period of the report
+func ParseReportPeriod(req *BookkeepingReportRequest) (*ReportPeriod, error) {
+	if !req.Granularity.Valid() {
+		return nil, apperror.ErrBadRequest
+	}
+	format := req.Format
+	if format == "" {
+		format = ReportCSV
+	}
+	if !format.Valid() {
+		return nil, apperror.ErrBadRequest
+	}
+	if req.Period != "" && (req.From != "" || req.To != "") {
+		return nil, apperror.ErrBadRequest
+	}
+
+	p := &ReportPeriod{Granularity: req.Granularity, Format: format}
+	if req.Period != "" {
+		start, months, err := parsePeriod(req.Period)
+		if err != nil {
+			return nil, err
+		}
+		p.Label = req.Period
+		p.Start = start
+		p.End = start.AddDate(0, months, 0)
+		return p, nil
+	}
+
+	from, err := time.Parse("2006-01-02", req.From)
+	if err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	to, err := time.Parse("2006-01-02", req.To)
+	if err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	if to.Before(from) || to.Sub(from) > maxReportDays*24*time.Hour {
+		return nil, apperror.ErrBadRequest
+	}
+	p.Label = fmt.Sprintf("%s_%s", req.From, req.To)
+	p.Start = from
+	p.End = to.AddDate(0, 0, 1)
+	return p, nil
+}
+
+// parsePeriod returns the start of the month, quarter or year and its length in months
+func parsePeriod(period string) (time.Time, int, error) {
+	if t, err := time.Parse("2006-01", period); err == nil {
+		return t, 1, nil
+	}
+	if t, err := time.Parse("2006", period); err == nil {
+		return t, 12, nil
+	}
+	parts := strings.Split(strings.ToUpper(period), "-Q")
+	if len(parts) == 2 {
+		year, err := time.Parse("2006", parts[0])
+		quarter, err2 := strconv.Atoi(parts[1])
+		if err == nil && err2 == nil && quarter >= 1 && quarter <= 4 {
+			return year.AddDate(0, (quarter-1)*3, 0), 3, nil
+		}
+	}
+	return time.Time{}, 0, apperror.ErrBadRequest
+}
@@ -0,0 +1,156 @@
+package cashaccount
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"user-balance-service/pkg/xlsx"
+)
+
+// ReportFormat is the file format of the bookkeeping report
+type ReportFormat string
+
+const (
+	ReportCSV  ReportFormat = "csv"
+	ReportXLSX ReportFormat = "xlsx"
+	ReportJSON ReportFormat = "json"
+)
+
+func (f ReportFormat) Valid() bool {
+	return f == ReportCSV || f == ReportXLSX || f == ReportJSON
+}
+
+// DefaultCSVSeparator is the separator of the example report in the task
+const DefaultCSVSeparator = ';'
+
+// ReportRenderer writes the bookkeeping report file
+type ReportRenderer interface {
+	Render(w io.Writer, report *BookkeepingReport, rows []*BookkeepingReportRow) error
+	ContentType() string
+	Extension() string
+}
+
+// NewReportRenderer returns the renderer of the format, separator is used by CSV
+func NewReportRenderer(format ReportFormat, separator rune) ReportRenderer {
+	switch format {
+	case ReportXLSX:
+		return xlsxReport{}
+	case ReportJSON:
+		return jsonReport{}
+	}
+	return csvReport{separator}
+}
+
+func reportHeader(report *BookkeepingReport) []string {
+	if report.Granularity != GranularityNone {
+		return []string{"period", "service_id", "amount"}
+	}
+	return []string{"service_id", "amount"}
+}
+
+type csvReport struct {
+	separator rune
+}
+
+func (c csvReport) Render(w io.Writer, report *BookkeepingReport, rows []*BookkeepingReportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = c.separator
+	if err := cw.Write(reportHeader(report)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{fmt.Sprintf("%d", row.ServiceId), fmt.Sprintf("%.2f", row.Amount)}
+		if report.Granularity != GranularityNone {
+			record = append([]string{row.Period.Format("2006-01-02")}, record...)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvReport) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (csvReport) Extension() string {
+	return "csv"
+}
+
+type xlsxReport struct{}
+
+func (xlsxReport) Render(w io.Writer, report *BookkeepingReport, rows []*BookkeepingReportRow) error {
+	xw, err := xlsx.NewWriter(w, "Report")
+	if err != nil {
+		return err
+	}
+	header := make([]interface{}, 0, 3)
+	for _, column := range reportHeader(report) {
+		header = append(header, column)
+	}
+	if err := xw.WriteRow(header...); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		cells := []interface{}{row.ServiceId, row.Amount}
+		if report.Granularity != GranularityNone {
+			cells = append([]interface{}{row.Period.Format("2006-01-02")}, cells...)
+		}
+		if err := xw.WriteRow(cells...); err != nil {
+			return err
+		}
+	}
+	return xw.Close()
+}
+
+func (xlsxReport) ContentType() string {
+	return xlsx.ContentType
+}
+
+func (xlsxReport) Extension() string {
+	return "xlsx"
+}
+
+type jsonReportRow struct {
+	Period    string  `json:"period,omitempty"`
+	ServiceId uint32  `json:"service_id"`
+	Amount    float32 `json:"amount"`
+}
+
+type jsonReportFile struct {
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	Granularity Granularity     `json:"granularity,omitempty"`
+	Rows        []jsonReportRow `json:"rows"`
+}
+
+type jsonReport struct{}
+
+func (jsonReport) Render(w io.Writer, report *BookkeepingReport, rows []*BookkeepingReportRow) error {
+	file := jsonReportFile{
+		PeriodStart: report.PeriodStart,
+		PeriodEnd:   report.PeriodEnd,
+		Granularity: report.Granularity,
+		Rows:        make([]jsonReportRow, 0, len(rows)),
+	}
+	for _, row := range rows {
+		item := jsonReportRow{ServiceId: row.ServiceId, Amount: row.Amount}
+		if report.Granularity != GranularityNone {
+			item.Period = row.Period.Format("2006-01-02")
+		}
+		file.Rows = append(file.Rows, item)
+	}
+	return json.NewEncoder(w).Encode(file)
+}
+
+func (jsonReport) ContentType() string {
+	return "application/json"
+}
+
+func (jsonReport) Extension() string {
+	return "json"
+}
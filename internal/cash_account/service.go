@@ -1,25 +1,33 @@
 package cashaccount
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/csv"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"path"
 	"time"
 	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/pkg/filestore"
 	"user-balance-service/pkg/logging"
 )
 
 type Service struct {
-	storage Storage
-	logger  *logging.Logger
+	storage       Storage
+	logger        *logging.Logger
+	spendPriority SpendPriority
+	bus           *events.Bus
+	// csvSeparator separates the columns of CSV bookkeeping reports
+	csvSeparator rune
+	reports      filestore.Store
 }
 
-func (s *Service) GetAmount(ctx context.Context, id uint32) (*UserAmount, error) {
+func (s *Service) GetAmount(ctx context.Context, id uint32) (*UserBalance, error) {
 	return s.storage.GetAmount(ctx, id)
 }
 
@@ -27,14 +35,39 @@ func (s *Service) TopUpMoney(ctx context.Context, data *UserAmount) error {
 	if data.Amount <= 0 {
 		return apperror.ErrBadRequest
 	}
-	return s.storage.TopUpMoney(ctx, data)
+	err := s.storage.TopUpMoney(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: data.Amount})
+	return nil
+}
+
+func (s *Service) TopUpBonus(ctx context.Context, data *BonusAmount) error {
+	if data.Amount <= 0 {
+		return apperror.ErrBadRequest
+	}
+	if !data.ExpiresAt.After(time.Now()) {
+		return apperror.ErrBadRequest
+	}
+	err := s.storage.TopUpBonus(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: data.Amount, Source: "bonus"})
+	return nil
 }
 
 func (s *Service) WithdrawMoney(ctx context.Context, data *UserAmount) error {
 	if data.Amount <= 0 {
 		return apperror.ErrBadRequest
 	}
-	return s.storage.WithdrawMoney(ctx, data)
+	err := s.storage.WithdrawMoney(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Withdraw, UserId: data.ID, Amount: data.Amount})
+	return nil
 }
 
 func (s *Service) Reserve(ctx context.Context, data *ReserveDetails) error {
@@ -44,17 +77,58 @@ func (s *Service) Reserve(ctx context.Context, data *ReserveDetails) error {
 	if data.OrderId <= 0 || data.ServiceId <= 0 {
 		return apperror.ErrBadRequest
 	}
-	return s.storage.ReserveMoney(ctx, data)
+	err := s.storage.ReserveMoney(ctx, data, s.spendPriority)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Reserve, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
+	return nil
 }
 
 func (s *Service) AcceptRevenue(ctx context.Context, data *ReserveDetails) error {
+	err := s.TryAcceptRevenue(ctx, data)
+	if errors.Is(err, ErrUnreserved) {
+		// the request succeeds as before, subscribers learn from the refund that nothing was accepted
+		return nil
+	}
+	return err
+}
+
+// TryAcceptRevenue is AcceptRevenue which returns ErrUnreserved when the revenue was not accepted
+// and the reserved money was returned to the user
+func (s *Service) TryAcceptRevenue(ctx context.Context, data *ReserveDetails) error {
 	if data.Amount <= 0 {
 		return apperror.ErrBadRequest
 	}
 	if data.OrderId <= 0 || data.ServiceId <= 0 {
 		return apperror.ErrBadRequest
 	}
-	return s.storage.AcceptRevenue(ctx, data)
+	err := s.storage.AcceptRevenue(ctx, data)
+	if errors.Is(err, ErrUnreserved) {
+		s.bus.Publish(ctx, &events.Event{Type: events.Refund, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Accept, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
+	return nil
+}
+
+// CancelReservation returns the reserved money when the order is cancelled
+func (s *Service) CancelReservation(ctx context.Context, data *ReserveDetails) error {
+	if data.Amount <= 0 {
+		return apperror.ErrBadRequest
+	}
+	if data.OrderId <= 0 || data.ServiceId <= 0 {
+		return apperror.ErrBadRequest
+	}
+	err := s.storage.CancelReservation(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Refund, UserId: data.ID, ServiceId: data.ServiceId, OrderId: data.OrderId, Amount: data.Amount})
+	return nil
 }
 
 func (s *Service) TransferBetweenUsers(ctx context.Context, data *MoneyTransferDetails) error {
@@ -64,16 +138,56 @@ func (s *Service) TransferBetweenUsers(ctx context.Context, data *MoneyTransferD
 	if data.ToId <= 0 || data.FromId <= 0 || data.FromId == data.ToId {
 		return apperror.ErrBadRequest
 	}
-	return s.storage.TransferBetweenUsers(ctx, data)
+	err := s.storage.TransferBetweenUsers(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Transfer, UserId: data.FromId, CounterpartyId: data.ToId, Amount: data.Amount})
+	return nil
 }
 
+// describe renders the description of a typed row, rows written before operations were typed keep their stored text
+func describe(row *UserReportRow, locale Locale) {
+	if row.Type != "" {
+		row.Description = row.Describe(locale, row.Amount)
+	}
+}
+
+func validateUserReportFilter(filter *UserReportFilter) error {
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return apperror.ErrBadRequest
+	}
+	if filter.Operation != "" && !filter.Operation.Valid() {
+		return apperror.ErrBadRequest
+	}
+	if filter.Direction != "" && !filter.Direction.Valid() {
+		return apperror.ErrBadRequest
+	}
+	if filter.MinAmount != nil && *filter.MinAmount < 0 || filter.MaxAmount != nil && *filter.MaxAmount < 0 {
+		return apperror.ErrBadRequest
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		return apperror.ErrBadRequest
+	}
+	return nil
+}
+
+// defaultReportPageSize is used when the page size is not set
+const defaultReportPageSize = 1000
+
 func (s *Service) GetUserReport(
 	ctx context.Context,
 	uid, pageNum, pageSize uint32,
-	sortBy, sortDirection string) ([]*UserReportRow, error) {
+	cursor string,
+	sortBy, sortDirection string,
+	filter *UserReportFilter,
+	locale Locale) (*UserReport, error) {
 	if pageNum != 0 {
 		pageNum -= 1
 	}
+	if pageSize == 0 {
+		pageSize = defaultReportPageSize
+	}
 	rowOffset := pageNum * pageSize
 	if sortBy != "dateTime" && sortBy != "amount" && sortBy != "" {
 		return nil, apperror.ErrBadRequest
@@ -84,72 +198,249 @@ func (s *Service) GetUserReport(
 	if sortBy == "dateTime" {
 		sortBy = "created_at"
 	}
-	res, err := s.storage.GetUserReport(ctx, uid, rowOffset, pageSize, sortBy, sortDirection)
+	if filter == nil {
+		filter = &UserReportFilter{}
+	}
+	if err := validateUserReportFilter(filter); err != nil {
+		return nil, err
+	}
+
+	var after *UserReportCursor
+	if cursor != "" {
+		if rowOffset != 0 {
+			return nil, apperror.ErrBadRequest
+		}
+		c, err := DecodeUserReportCursor(cursor, sortBy, sortDirection)
+		if err != nil {
+			return nil, err
+		}
+		after = c
+	}
+
+	// one more row tells whether there is a next page
+	res, err := s.storage.GetUserReport(ctx, uid, rowOffset, pageSize+1, sortBy, sortDirection, filter, after)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	report := &UserReport{Rows: res}
+	if uint32(len(res)) > pageSize {
+		report.Rows = res[:pageSize]
+		report.NextCursor = newUserReportCursor(report.Rows[pageSize-1], sortBy, sortDirection).Encode()
+	}
+	for _, row := range report.Rows {
+		describe(row, locale)
+	}
+
+	report.Total, err = s.storage.CountUserReport(ctx, uid, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ExportUserReport writes the statement of the user for the period of the filter. The opening and closing
+// balances are taken for the whole period, the other conditions of the filter only select the rows
+func (s *Service) ExportUserReport(ctx context.Context, uid uint32, format ExportFormat, filter *UserReportFilter, locale Locale, w io.Writer) error {
+	if !format.Valid() {
+		return apperror.ErrBadRequest
+	}
+	if filter == nil {
+		filter = &UserReportFilter{}
+	}
+	if err := validateUserReportFilter(filter); err != nil {
+		return err
+	}
+	if _, err := s.storage.GetAmount(ctx, uid); err != nil {
+		return err
+	}
+
+	statement := &Statement{UserId: uid, From: filter.From, To: filter.To, Totals: make(map[OperationType]float32), Locale: locale}
+	if statement.To.IsZero() {
+		statement.To = time.Now().UTC()
+	}
+
+	var err error
+	if !filter.From.IsZero() {
+		statement.OpeningBalance, err = s.storage.GetBalanceAt(ctx, uid, filter.From)
+		if err != nil {
+			return err
+		}
+	}
+	statement.ClosingBalance, err = s.storage.GetBalanceAt(ctx, uid, filter.To)
+	if err != nil {
+		return err
+	}
+
+	sw, err := newStatementWriter(format, w, statement)
+	if err != nil {
+		return err
+	}
+	err = s.storage.StreamUserReport(ctx, uid, filter, func(row *UserReportRow) error {
+		describe(row, locale)
+		statement.add(row)
+		return sw.Row(row)
+	})
+	if err != nil {
+		return err
+	}
+	return sw.Close(statement)
 }
 
-func (s *Service) CreateBookkeepingReport(ctx context.Context, reportTime string) (string, error) {
-	t, err := time.Parse("2006-01", reportTime)
+// GetBalanceUpdate returns the current balance of the user with the latest operation
+func (s *Service) GetBalanceUpdate(ctx context.Context, id uint32, locale Locale) (*BalanceUpdate, error) {
+	balance, err := s.storage.GetAmount(ctx, id)
 	if err != nil {
+		return nil, err
+	}
+	last, err := s.storage.GetLastUserReport(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		describe(last, locale)
+	}
+	return &BalanceUpdate{Balance: balance, LastOperation: last}, nil
+}
+
+// reportsDir is the prefix of the report keys in the file store
+const reportsDir = "reports"
+
+// newReportKey returns a unique name of the report which starts with its period
+func newReportKey(label string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
 		return "", err
 	}
-	startTime := t.Format("2006-01-02 15:04:05")
-	endTime := t.AddDate(0, 1, 0).Format("2006-01-02 15:04:05")
-	report, err2 := s.storage.CreateReport(ctx, startTime, endTime)
+	return fmt.Sprintf("%s-%s", label, hex.EncodeToString(suffix)), nil
+}
+
+func (s *Service) CreateBookkeepingReport(ctx context.Context, req *BookkeepingReportRequest) (*BookkeepingReport, error) {
+	period, err := ParseReportPeriod(req)
+	if err != nil {
+		return nil, err
+	}
+	creator := req.Creator
+	if creator == "" {
+		creator = "api"
+	}
+	startTime := period.Start.Format("2006-01-02 15:04:05")
+	endTime := period.End.Format("2006-01-02 15:04:05")
+	report, err2 := s.storage.CreateReport(ctx, startTime, endTime, period.Granularity)
 	if err2 != nil {
-		return "", err2
+		return nil, err2
 	}
 
-	// write to csv file
-	f, err := os.Create("report.csv")
+	key, err := newReportKey(period.Label)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	w := csv.NewWriter(f)
-	for _, record := range report {
-		row := []string{fmt.Sprintf("%d", record.ServiceId), fmt.Sprintf("%f", record.Amount)}
-		if err := w.Write(row); err != nil {
-			return "", err
-		}
+	renderer := NewReportRenderer(period.Format, s.csvSeparator)
+
+	res := &BookkeepingReport{
+		Key:         key,
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+		Granularity: period.Granularity,
+		Format:      period.Format,
+		CreatedBy:   creator,
 	}
-	w.Flush()
 
-	//calculate hash, define path
+	// the file is named by the hash of its content, which is known only after rendering
+	var buf bytes.Buffer
+	h := sha256.New()
+	if err := renderer.Render(io.MultiWriter(&buf, h), res, report); err != nil {
+		return nil, err
+	}
+	res.Hash = hex.EncodeToString(h.Sum(nil))
+	res.Path = path.Join(reportsDir, res.Hash+"."+renderer.Extension())
+
+	// a report with the same content is stored under the same key, so writing it again is harmless
+	if err := s.reports.Put(ctx, res.Path, &buf); err != nil {
+		return nil, err
+	}
 
-	path, err := os.Getwd()
+	err = s.storage.SaveReport(ctx, res)
 	if err != nil {
-		os.Remove("report.csv")
-		return "", err
+		return nil, err
 	}
+	return res, nil
+}
 
-	path = filepath.Join(path, f.Name())
+// GetBookkeepingReport finds the report by its key or the hash of its content
+func (s *Service) GetBookkeepingReport(ctx context.Context, key string) (*BookkeepingReport, error) {
+	return s.storage.GetReport(ctx, key)
+}
 
-	h := md5.New()
+func fileHash(f io.Reader) (string, error) {
+	h := sha256.New()
 	if _, err := io.Copy(h, f); err != nil {
-		os.Remove("report.csv")
 		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	hash := hex.EncodeToString(h.Sum(nil))
+// VerifyBookkeepingReport recomputes the hash of the report file and compares it with the stored one
+func (s *Service) VerifyBookkeepingReport(ctx context.Context, key string) (*ReportVerification, error) {
+	report, err := s.storage.GetReport(ctx, key)
+	if err != nil {
+		return nil, err
+	}
 
-	//store data
-	err = s.storage.SaveReport(ctx, hash, path)
+	res := &ReportVerification{Key: report.Key, Hash: report.Hash}
+	f, err := s.reports.Get(ctx, report.Path)
+	if errors.Is(err, filestore.ErrNotExist) {
+		return res, nil
+	}
 	if err != nil {
-		os.Remove("report.csv")
-		return "", err
+		return nil, err
+	}
+	defer f.Close()
+
+	res.ActualHash, err = fileHash(f)
+	if err != nil {
+		return nil, err
+	}
+	res.Valid = res.ActualHash == report.Hash
+	return res, nil
+}
+
+// OpenBookkeepingReport returns the content of the report if it matches the stored hash.
+// Reports are small, so the content is read into memory to check it before it is served
+func (s *Service) OpenBookkeepingReport(ctx context.Context, key string) (*BookkeepingReport, io.ReadSeeker, error) {
+	report, err := s.storage.GetReport(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := s.reports.Get(ctx, report.Path)
+	if errors.Is(err, filestore.ErrNotExist) {
+		s.logger.Errorf("File of the bookkeeping report %s is missing", report.Key)
+		return nil, nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(content)
+	if hash := hex.EncodeToString(sum[:]); hash != report.Hash {
+		s.logger.Errorf("Content of the bookkeeping report %s does not match its hash %s, got %s", report.Key, report.Hash, hash)
+		return nil, nil, apperror.ErrConflict
 	}
-	return hash, nil
+	return report, bytes.NewReader(content), nil
 }
 
-func (s *Service) GetBookkeepingReport(ctx context.Context, hash string) (string, error) {
-	return s.storage.GetReport(ctx, hash)
+func (s *Service) ListBookkeepingReports(ctx context.Context) ([]*BookkeepingReport, error) {
+	return s.storage.ListReports(ctx)
 }
 
-func NewService(st Storage, logger *logging.Logger) *Service {
-	return &Service{st, logger}
+func NewService(st Storage, logger *logging.Logger, spendPriority SpendPriority, bus *events.Bus, csvSeparator rune, reports filestore.Store) *Service {
+	return &Service{st, logger, spendPriority, bus, csvSeparator, reports}
 }
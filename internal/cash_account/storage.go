@@ -1,16 +1,41 @@
 package cashaccount
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+	"user-balance-service/internal/apperror"
+)
+
+// ErrUnreserved is returned by AcceptRevenue when the revenue could not be accepted
+// and the reserved money was returned to the user
+var ErrUnreserved = errors.New("Revenue was not accepted, the reserved money was returned")
+
+// ErrInsufficientFunds is wrapped by the errors of operations the user can not pay for
+var ErrInsufficientFunds = apperror.NewAppError(nil, "insufficient funds", "BS-000005")
 
 type Storage interface {
 	TopUpMoney(context.Context, *UserAmount) error
+	TopUpBonus(context.Context, *BonusAmount) error
 	WithdrawMoney(context.Context, *UserAmount) error
-	GetAmount(context.Context, uint32) (*UserAmount, error)
+	GetAmount(context.Context, uint32) (*UserBalance, error)
 	TransferBetweenUsers(context.Context, *MoneyTransferDetails) error
-	ReserveMoney(context.Context, *ReserveDetails) error
+	ReserveMoney(context.Context, *ReserveDetails, SpendPriority) error
 	AcceptRevenue(ctx context.Context, data *ReserveDetails) error
-	GetUserReport(ctx context.Context, uid, rowOffest, pageSize uint32, sortBy, sortDirection string) ([]*UserReportRow, error)
-	CreateReport(ctx context.Context, timeStart, timeEnd string) ([]*BookkeepingReportRow, error)
-	SaveReport(ctx context.Context, hash, path string) error
-	GetReport(ctx context.Context, hash string) (string, error)
+	CancelReservation(ctx context.Context, data *ReserveDetails) error
+	// GetUserReport starts the page after the cursor if it is not nil, otherwise at rowOffset
+	GetUserReport(ctx context.Context, uid, rowOffest, pageSize uint32, sortBy, sortDirection string, filter *UserReportFilter, cursor *UserReportCursor) ([]*UserReportRow, error)
+	CountUserReport(ctx context.Context, uid uint32, filter *UserReportFilter) (uint32, error)
+	// StreamUserReport calls fn for every row matching the filter in chronological order without loading all of them
+	StreamUserReport(ctx context.Context, uid uint32, filter *UserReportFilter, fn func(*UserReportRow) error) error
+	// GetBalanceAt returns the balance after the last operation before t, zero t means now
+	GetBalanceAt(ctx context.Context, uid uint32, t time.Time) (float32, error)
+	// GetLastUserReport returns nil if the user has no operations yet
+	GetLastUserReport(ctx context.Context, uid uint32) (*UserReportRow, error)
+	// CreateReport sums the revenue of every service, split by the granularity periods
+	CreateReport(ctx context.Context, timeStart, timeEnd string, granularity Granularity) ([]*BookkeepingReportRow, error)
+	SaveReport(ctx context.Context, report *BookkeepingReport) error
+	// GetReport finds the report by the key or the hash, the latest report is returned for the hash
+	GetReport(ctx context.Context, key string) (*BookkeepingReport, error)
+	ListReports(ctx context.Context) ([]*BookkeepingReport, error)
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"user-balance-service/internal/apperror"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/closing"
+	"user-balance-service/pkg/logging"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	errDuplicateEntry = 1062
+	// errPeriodClosed is raised by the bookkeeping triggers with SIGNAL
+	errPeriodClosed = 1644
+)
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: 0})
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("Rollback error")
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const closedPeriodColumns = `id, period, period_start, period_end, coalesce(report_key, ''), coalesce(report_hash, ''), closed_by, closed_at`
+
+func scanClosedPeriod(row interface{ Scan(...any) error }) (*closing.ClosedPeriod, error) {
+	item := new(closing.ClosedPeriod)
+	err := row.Scan(&item.ID, &item.Period, &item.Start, &item.End, &item.ReportKey, &item.ReportHash, &item.ClosedBy, &item.ClosedAt)
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (d *db) Close(ctx context.Context, period *closing.ClosedPeriod) (*closing.ClosedPeriod, bool, error) {
+	_, err := d.ExecContext(ctx, `insert into closed_period (period, period_start, period_end, closed_by) values (?, ?, ?, ?);`,
+		period.Period, period.Start.Format("2006-01-02"), period.End.Format("2006-01-02"), period.ClosedBy)
+	created := true
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || mysqlErr.Number != errDuplicateEntry {
+			d.logger.Errorf("Error %s in closing period %s", err, period.Period)
+			return nil, false, err
+		}
+		created = false
+	}
+
+	res, err := d.Get(ctx, period.Period)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, created, nil
+}
+
+func (d *db) SetReport(ctx context.Context, id uint32, key, hash string) error {
+	_, err := d.ExecContext(ctx, `update closed_period set report_key = ?, report_hash = ? where id = ?;`, key, hash, id)
+	return err
+}
+
+func (d *db) Get(ctx context.Context, period string) (*closing.ClosedPeriod, error) {
+	return scanClosedPeriod(d.QueryRowContext(ctx, `select `+closedPeriodColumns+` from closed_period where period = ?;`, period))
+}
+
+func (d *db) List(ctx context.Context) ([]*closing.ClosedPeriod, error) {
+	rows, err := d.QueryContext(ctx, `select `+closedPeriodColumns+` from closed_period order by period desc;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*closing.ClosedPeriod, 0)
+	for rows.Next() {
+		item, err := scanClosedPeriod(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) SaveAdjustment(ctx context.Context, adjustment *closing.Adjustment) error {
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		id, err := cashaccountdb.AddAdjustment(tx, adjustment.ServiceId, adjustment.Amount, adjustment.Period, adjustment.Reason, adjustment.CreatedBy)
+		if err != nil {
+			return err
+		}
+		adjustment.ID = uint32(id)
+		return tx.QueryRow(`select created_at from bookkeeping where id = ?;`, id).Scan(&adjustment.CreatedAt)
+	})
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errPeriodClosed {
+			return apperror.ErrConflict
+		}
+		d.logger.Errorf("Error %s in adjustment of period %s service: %d amount: %f", err, adjustment.Period, adjustment.ServiceId, adjustment.Amount)
+		return err
+	}
+	d.logger.Infof("Adjusted period %s service: %d amount: %f by %s", adjustment.Period, adjustment.ServiceId, adjustment.Amount, adjustment.CreatedBy)
+	return nil
+}
+
+func (d *db) GetAdjustments(ctx context.Context, period string) ([]*closing.Adjustment, error) {
+	rows, err := d.QueryContext(ctx, `select id, adjusts_period, service_id, amount, reason, created_by, created_at from bookkeeping where adjusts_period = ? order by id;`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*closing.Adjustment, 0)
+	for rows.Next() {
+		item := new(closing.Adjustment)
+		if err := rows.Scan(&item.ID, &item.Period, &item.ServiceId, &item.Amount, &item.Reason, &item.CreatedBy, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	return res, rows.Err()
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) closing.Storage {
+	return &db{database, logger}
+}
@@ -0,0 +1,115 @@
+package closing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	links   *cashaccount.LinkSigner
+	admin   *middleware.Token
+	logger  *logging.Logger
+}
+
+// PeriodResponse has the download link of the frozen report and the corrections of the period
+type PeriodResponse struct {
+	*ClosedPeriod
+	Link          string        `json:"link,omitempty"`
+	LinkExpiresAt *time.Time    `json:"link_expires_at,omitempty"`
+	Adjustments   []*Adjustment `json:"adjustments"`
+}
+
+func NewHandler(service *Service, links *cashaccount.LinkSigner, admin *middleware.Token, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		links:   links,
+		admin:   admin,
+		logger:  logger,
+	}
+}
+
+// Register requires the admin token to close periods and to adjust them
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/periods/", middleware.Middleware(h.admin.Admin(h.Close)))
+	router.HandlerFunc(http.MethodGet, "/api/periods/", middleware.Middleware(h.List))
+	router.HandlerFunc(http.MethodGet, "/api/periods/:period", middleware.Middleware(h.Get))
+	router.HandlerFunc(http.MethodPost, "/api/periods/:period/adjustments", middleware.Middleware(h.admin.Admin(h.Adjust)))
+}
+
+// response has the download link of the report only for the clients with the token of report links
+func (h *handler) response(r *http.Request, period *ClosedPeriod, adjustments []*Adjustment) *PeriodResponse {
+	resp := &PeriodResponse{ClosedPeriod: period, Adjustments: adjustments}
+	if period.ReportKey != "" && h.links.Authorized(r) {
+		link, expiresAt := h.links.Link(period.ReportKey)
+		resp.Link = link
+		resp.LinkExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+func (h *handler) Close(w http.ResponseWriter, r *http.Request) error {
+	var data CloseRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	period, err := h.service.Close(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h.response(r, period, []*Adjustment{}))
+	return nil
+}
+
+func (h *handler) List(w http.ResponseWriter, r *http.Request) error {
+	periods, err := h.service.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(periods)
+	return nil
+}
+
+func (h *handler) Get(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	period, err := h.service.Get(context.Background(), params.ByName("period"))
+	if err != nil {
+		return err
+	}
+	adjustments, err := h.service.GetAdjustments(context.Background(), period.Period)
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(h.response(r, period, adjustments))
+	return nil
+}
+
+func (h *handler) Adjust(w http.ResponseWriter, r *http.Request) error {
+	var data Adjustment
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return apperror.ErrBadRequest
+	}
+	data.Period = httprouter.ParamsFromContext(r.Context()).ByName("period")
+
+	if err := h.service.Adjust(context.Background(), &data); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(data)
+	return nil
+}
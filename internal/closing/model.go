@@ -0,0 +1,35 @@
+package closing
+
+import "time"
+
+// ClosedPeriod is a month whose bookkeeping can not be changed anymore. The report
+// generated on closing is frozen, the report of the period is always the same
+type ClosedPeriod struct {
+	ID uint32 `json:"id"`
+	// Period is the month, 2006-01
+	Period string    `json:"period"`
+	Start  time.Time `json:"period_start"`
+	// End is the first day of the next month
+	End        time.Time `json:"period_end"`
+	ReportKey  string    `json:"report_key,omitempty"`
+	ReportHash string    `json:"report_hash,omitempty"`
+	ClosedBy   string    `json:"closed_by"`
+	ClosedAt   time.Time `json:"closed_at"`
+}
+
+type CloseRequest struct {
+	Period   string `json:"period"`
+	ClosedBy string `json:"closed_by"`
+}
+
+// Adjustment corrects the revenue of the closed Period. It is written
+// to the bookkeeping of the day it was made, which belongs to an open period
+type Adjustment struct {
+	ID        uint32    `json:"id"`
+	Period    string    `json:"period"`
+	ServiceId uint32    `json:"service_id"`
+	Amount    float32   `json:"amount"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
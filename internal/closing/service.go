@@ -0,0 +1,107 @@
+package closing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+// maxReasonLength fits bookkeeping.reason column
+const maxReasonLength = 255
+
+// Generator builds the report, it is implemented by the cash account service
+type Generator interface {
+	CreateBookkeepingReport(ctx context.Context, req *cashaccount.BookkeepingReportRequest) (*cashaccount.BookkeepingReport, error)
+}
+
+type Service struct {
+	storage   Storage
+	generator Generator
+	logger    *logging.Logger
+}
+
+// Close freezes the bookkeeping of the month which is over and generates its report.
+// If the report of the closed period could not be generated before, closing it again retries
+func (s *Service) Close(ctx context.Context, data *CloseRequest) (*ClosedPeriod, error) {
+	// months are in UTC like the periods of the bookkeeping reports
+	start, err := time.Parse("2006-01", data.Period)
+	if err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	end := start.AddDate(0, 1, 0)
+	if time.Now().Before(end) {
+		// operations of the current month are still coming
+		return nil, apperror.ErrBadRequest
+	}
+	if data.ClosedBy == "" {
+		data.ClosedBy = "api"
+	}
+
+	period, created, err := s.storage.Close(ctx, &ClosedPeriod{Period: data.Period, Start: start, End: end, ClosedBy: data.ClosedBy})
+	if err != nil {
+		return nil, err
+	}
+	if !created && period.ReportKey != "" {
+		return nil, apperror.ErrConflict
+	}
+
+	// the bookkeeping of the period can not change anymore, so the report is final
+	report, err := s.generator.CreateBookkeepingReport(ctx, &cashaccount.BookkeepingReportRequest{Period: data.Period, Creator: data.ClosedBy})
+	if err != nil {
+		s.logger.Errorf("Error %s in the report of the closed period %s", err, data.Period)
+		return nil, err
+	}
+	if err := s.storage.SetReport(ctx, period.ID, report.Key, report.Hash); err != nil {
+		return nil, err
+	}
+	period.ReportKey = report.Key
+	period.ReportHash = report.Hash
+	s.logger.Infof("Closed period %s by %s, report %s", period.Period, period.ClosedBy, report.Key)
+	return period, nil
+}
+
+func (s *Service) Get(ctx context.Context, period string) (*ClosedPeriod, error) {
+	if _, err := time.Parse("2006-01", period); err != nil {
+		return nil, apperror.ErrBadRequest
+	}
+	return s.storage.Get(ctx, period)
+}
+
+func (s *Service) List(ctx context.Context) ([]*ClosedPeriod, error) {
+	return s.storage.List(ctx)
+}
+
+// Adjust corrects the revenue of the closed period in the current open period
+func (s *Service) Adjust(ctx context.Context, data *Adjustment) error {
+	data.Reason = strings.TrimSpace(data.Reason)
+	if data.ServiceId == 0 || data.Amount == 0 || data.Reason == "" || len(data.Reason) > maxReasonLength {
+		return apperror.ErrBadRequest
+	}
+	if data.CreatedBy == "" {
+		data.CreatedBy = "api"
+	}
+
+	// only closed periods are corrected with adjustments
+	if _, err := s.Get(ctx, data.Period); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return apperror.ErrBadRequest
+		}
+		return err
+	}
+	return s.storage.SaveAdjustment(ctx, data)
+}
+
+func (s *Service) GetAdjustments(ctx context.Context, period string) ([]*Adjustment, error) {
+	if _, err := s.Get(ctx, period); err != nil {
+		return nil, err
+	}
+	return s.storage.GetAdjustments(ctx, period)
+}
+
+func NewService(st Storage, generator Generator, logger *logging.Logger) *Service {
+	return &Service{st, generator, logger}
+}
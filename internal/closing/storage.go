@@ -0,0 +1,17 @@
+package closing
+
+import "context"
+
+type Storage interface {
+	// Close saves the closed period. If the period is already closed the saved one is returned and created is false
+	Close(ctx context.Context, period *ClosedPeriod) (res *ClosedPeriod, created bool, err error)
+	// SetReport saves the frozen report of the closed period
+	SetReport(ctx context.Context, id uint32, key, hash string) error
+	Get(ctx context.Context, period string) (*ClosedPeriod, error)
+	// List returns the closed periods from the latest
+	List(ctx context.Context) ([]*ClosedPeriod, error)
+	// SaveAdjustment returns apperror.ErrConflict if the current day belongs to a closed period
+	SaveAdjustment(ctx context.Context, adjustment *Adjustment) error
+	// GetAdjustments returns the corrections of the closed period in the order they were made
+	GetAdjustments(ctx context.Context, period string) ([]*Adjustment, error)
+}
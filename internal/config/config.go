@@ -2,6 +2,7 @@ package config
 
 import (
 	"sync"
+	"time"
 	"user-balance-service/pkg/logging"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -12,6 +13,10 @@ type Config struct {
 		BindIp string `yaml:"bind_ip" env-default:"127.0.0.1"`
 		Port   string `yaml:"port" env-default:"8080"`
 	}
+	Admin struct {
+		// Token is sent by the administrators in the Authorization: Bearer header, it is not kept in config.yml
+		Token string `yaml:"token" env:"ADMIN_TOKEN"`
+	}
 	Database struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password" env-default:"root"`
@@ -19,6 +24,95 @@ type Config struct {
 		Port     string `yaml:"port" env-default:"3306"`
 		Database string `yaml:"database"`
 	}
+	Bonus struct {
+		SpendPriority string `yaml:"spend_priority" env-default:"bonus_first"`
+	}
+	Payment struct {
+		// Provider has no default, the fake provider is chosen explicitly for development and tests
+		Provider    string `yaml:"provider" env:"PAYMENT_PROVIDER"`
+		CallbackUrl string `yaml:"callback_url" env-default:"http://localhost:8080/api/payments/callback"`
+		// Secret signs the callbacks of the provider, it is not kept in config.yml
+		Secret      string `yaml:"secret" env:"PAYMENT_SECRET"`
+		FakeBaseUrl string `yaml:"fake_base_url" env-default:"http://localhost:8080"`
+	}
+	Payout struct {
+		Gateway              string        `yaml:"gateway" env-default:"simulator"`
+		PollInterval         time.Duration `yaml:"poll_interval" env-default:"5s"`
+		SimulatorDelay       time.Duration `yaml:"simulator_delay" env-default:"10s"`
+		SimulatorFailureRate float64       `yaml:"simulator_failure_rate" env-default:"0"`
+	}
+	Webhook struct {
+		MaxAttempts  uint32        `yaml:"max_attempts" env-default:"8"`
+		BaseBackoff  time.Duration `yaml:"base_backoff" env-default:"10s"`
+		MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"1h"`
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"2s"`
+		Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+		Concurrency  uint32        `yaml:"concurrency" env-default:"8"`
+	}
+	Outbox struct {
+		Publisher    string        `yaml:"publisher" env-default:"file"`
+		FilePath     string        `yaml:"file_path" env-default:"outbox.ndjson"`
+		KafkaBrokers []string      `yaml:"kafka_brokers"`
+		KafkaTopic   string        `yaml:"kafka_topic" env-default:"balance-events"`
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		BatchSize    uint32        `yaml:"batch_size" env-default:"100"`
+	}
+	FileStore struct {
+		Type        string        `yaml:"type" env-default:"local"`
+		LocalDir    string        `yaml:"local_dir" env-default:"files"`
+		S3Endpoint  string        `yaml:"s3_endpoint"`
+		S3Region    string        `yaml:"s3_region" env-default:"us-east-1"`
+		S3Bucket    string        `yaml:"s3_bucket"`
+		S3AccessKey string        `yaml:"s3_access_key"`
+		S3SecretKey string        `yaml:"s3_secret_key"`
+		S3PathStyle bool          `yaml:"s3_path_style" env-default:"true"`
+		S3Timeout   time.Duration `yaml:"s3_timeout" env-default:"30s"`
+	} `yaml:"file_store"`
+	Statements struct {
+		Enabled  bool          `yaml:"enabled" env-default:"true"`
+		Format   string        `yaml:"format" env-default:"pdf"`
+		Locale   string        `yaml:"locale" env-default:"en"`
+		Interval time.Duration `yaml:"interval" env-default:"1h"`
+	}
+	Reports struct {
+		CsvSeparator string `yaml:"csv_separator" env-default:";"`
+		// PublicUrl is the address of the service for clients, download links start with it
+		PublicUrl string `yaml:"public_url" env-default:"http://localhost:8080"`
+		// LinkSecret signs download links, it is not kept in config.yml
+		LinkSecret string `yaml:"link_secret" env:"REPORT_LINK_SECRET"`
+		// LinkToken is sent by the clients which receive download links in the Authorization: Bearer header
+		LinkToken    string        `yaml:"link_token" env:"REPORT_LINK_TOKEN"`
+		LinkTTL      time.Duration `yaml:"link_ttl" env-default:"24h"`
+		Workers      int           `yaml:"workers" env-default:"2"`
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"5s"`
+		// JobLease is the time after which the job of a stopped worker is taken by another worker
+		JobLease time.Duration `yaml:"job_lease" env-default:"1m"`
+	}
+	ReportSchedule struct {
+		Enabled     bool   `yaml:"enabled" env-default:"false"`
+		Day         int    `yaml:"day" env-default:"1"`
+		Time        string `yaml:"time" env-default:"06:00"`
+		Timezone    string `yaml:"timezone" env-default:"UTC"`
+		Format      string `yaml:"format" env-default:"csv"`
+		Granularity string `yaml:"granularity"`
+		// CheckInterval is how often the replicas check whether the report is due
+		CheckInterval time.Duration `yaml:"check_interval" env-default:"1m"`
+		WebhookUrl    string        `yaml:"webhook_url"`
+		WebhookSecret string        `yaml:"webhook_secret"`
+		SmtpAddr      string        `yaml:"smtp_addr"`
+		SmtpUsername  string        `yaml:"smtp_username"`
+		SmtpPassword  string        `yaml:"smtp_password"`
+		EmailFrom     string        `yaml:"email_from"`
+		EmailTo       []string      `yaml:"email_to"`
+	} `yaml:"report_schedule"`
+	Orders struct {
+		Enabled       bool     `yaml:"enabled" env-default:"false"`
+		Broker        string   `yaml:"broker" env-default:"kafka"`
+		KafkaBrokers  []string `yaml:"kafka_brokers"`
+		CommandsTopic string   `yaml:"commands_topic" env-default:"order-commands"`
+		RepliesTopic  string   `yaml:"replies_topic" env-default:"order-replies"`
+		GroupId       string   `yaml:"group_id" env-default:"user-balance-service"`
+	}
 }
 
 var instance *Config
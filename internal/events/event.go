@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	Accrual  Type = "accrual"
+	Withdraw Type = "withdraw"
+	Reserve  Type = "reserve"
+	Accept   Type = "accept"
+	Transfer Type = "transfer"
+	Refund   Type = "refund"
+)
+
+var Types = []Type{Accrual, Withdraw, Reserve, Accept, Transfer, Refund}
+
+func (t Type) Valid() bool {
+	for _, v := range Types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Direction tells which side of a transfer the event describes
+type Direction string
+
+const (
+	Debit  Direction = "debit"
+	Credit Direction = "credit"
+)
+
+// Event describes a change of the user balance
+type Event struct {
+	Type           Type   `json:"type"`
+	UserId         uint32 `json:"user_id"`
+	CounterpartyId uint32 `json:"counterparty_id,omitempty"`
+	// Direction is set when the event describes one side of a transfer, UserId is then the only user it changes
+	Direction Direction `json:"direction,omitempty"`
+	ServiceId uint32    `json:"service_id,omitempty"`
+	OrderId   uint32    `json:"order_id,omitempty"`
+	Amount    float32   `json:"amount"`
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Users returns the ids of all users whose balance was changed by the event
+func (e *Event) Users() []uint32 {
+	if e.Type == Transfer && e.CounterpartyId != 0 && e.Direction == "" {
+		return []uint32{e.UserId, e.CounterpartyId}
+	}
+	return []uint32{e.UserId}
+}
+
+type Listener interface {
+	Handle(ctx context.Context, e *Event)
+}
+
+// Bus delivers events to the listeners subscribed in this process
+type Bus struct {
+	mu        sync.RWMutex
+	listeners []Listener
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(l Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, l)
+}
+
+// Publish is safe to call on a nil bus, so services may run without listeners
+func (b *Bus) Publish(ctx context.Context, e *Event) {
+	if b == nil {
+		return
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	b.mu.RLock()
+	listeners := b.listeners
+	b.mu.RUnlock()
+	for _, l := range listeners {
+		l.Handle(ctx, e)
+	}
+}
@@ -1,7 +1,5 @@
 package handlers
 
-import "github.com/julienschmidt/httprouter"
-
 type Handler interface {
-	Register(router *httprouter.Router)
+	Register(router *Router)
 }
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Router is httprouter which also serves the routes httprouter does not allow next to the static routes
+// with the same prefix, like /api/users/:id/stream next to /api/users/balance/:id
+type Router struct {
+	*httprouter.Router
+	routes []route
+}
+
+type route struct {
+	method  string
+	parts   []string
+	handler http.HandlerFunc
+}
+
+func NewRouter() *Router {
+	return &Router{Router: httprouter.New()}
+}
+
+// Dispatch registers the route which conflicts with the routes of httprouter,
+// it is matched only when no route of httprouter matches the request
+func (r *Router) Dispatch(method, path string, handler http.HandlerFunc) {
+	r.routes = append(r.routes, route{method, strings.Split(path, "/"), handler})
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if handle, _, _ := r.Lookup(req.Method, req.URL.Path); handle == nil {
+		parts := strings.Split(req.URL.Path, "/")
+		for _, rt := range r.routes {
+			if params, ok := rt.match(req.Method, parts); ok {
+				rt.handler(w, req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params)))
+				return
+			}
+		}
+	}
+	r.Router.ServeHTTP(w, req)
+}
+
+func (rt *route) match(method string, parts []string) (httprouter.Params, bool) {
+	if rt.method != method || len(rt.parts) != len(parts) {
+		return nil, false
+	}
+	var params httprouter.Params
+	for i, part := range rt.parts {
+		if strings.HasPrefix(part, ":") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params = append(params, httprouter.Param{Key: part[1:], Value: parts[i]})
+		} else if part != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"user-balance-service/internal/apperror"
+)
+
+// Token protects the administrative endpoints, the clients send it in the Authorization: Bearer header
+type Token struct {
+	value []byte
+}
+
+func NewToken(value string) (*Token, error) {
+	if value == "" {
+		return nil, fmt.Errorf("Admin token is not set")
+	}
+	return &Token{value: []byte(value)}, nil
+}
+
+// Authorized reports whether the request has the token
+func (t *Token) Authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), t.value) == 1
+}
+
+// Admin rejects the requests without the token with ErrForbidden
+func (t *Token) Admin(h appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !t.Authorized(r) {
+			return apperror.ErrForbidden
+		}
+		return h(w, r)
+	}
+}
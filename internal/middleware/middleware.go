@@ -30,6 +30,16 @@ func Middleware(h appHandler) http.HandlerFunc {
 					w.Write(apperror.ErrBadRequest.Marshal())
 					return
 				}
+				if errors.Is(err, apperror.ErrConflict) {
+					w.WriteHeader(409)
+					w.Write(apperror.ErrConflict.Marshal())
+					return
+				}
+				if errors.Is(err, apperror.ErrForbidden) {
+					w.WriteHeader(403)
+					w.Write(apperror.ErrForbidden.Marshal())
+					return
+				}
 			}
 
 			w.WriteHeader(500)
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/orders"
+	"user-balance-service/pkg/logging"
+)
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) GetReply(ctx context.Context, messageId string) (*orders.Reply, error) {
+	var payload []byte
+	err := d.QueryRowContext(ctx, `select reply from processed_message where message_id = ?;`, messageId).Scan(&payload)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply := new(orders.Reply)
+	if err := json.Unmarshal(payload, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (d *db) SaveReply(ctx context.Context, reply *orders.Reply) error {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	_, err = d.ExecContext(ctx, `insert into processed_message (message_id, reply) values (?, ?) on duplicate key update reply = reply;`, reply.MessageId, payload)
+	if err != nil {
+		d.logger.Errorf("Error %s in saving reply to message %s", err, reply.MessageId)
+	}
+	return err
+}
+
+func (d *db) WithReply(ctx context.Context, reply *orders.Reply) context.Context {
+	return cashaccountdb.WithTxHook(ctx, func(tx *sql.Tx, result error) error {
+		saved := *reply
+		if result != nil {
+			// the accept returned the reserved money, the command is answered with the error
+			saved.Status = orders.ReplyError
+			saved.Error = result.Error()
+		}
+		payload, err := json.Marshal(&saved)
+		if err != nil {
+			return err
+		}
+		// a concurrent delivery of the message fails on the primary key and its change is rolled back
+		_, err = tx.Exec(`insert into processed_message (message_id, reply) values (?, ?);`, reply.MessageId, payload)
+		return err
+	})
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) orders.Storage {
+	return &db{database, logger}
+}
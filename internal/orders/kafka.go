@@ -0,0 +1,95 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConsumer reads commands from a topic of a Kafka compatible broker as a member of the consumer group
+type KafkaConsumer struct {
+	reader *kafka.Reader
+
+	mu   sync.Mutex
+	last kafka.Message
+}
+
+func NewKafkaConsumer(brokers []string, topic, groupId string) *KafkaConsumer {
+	return &KafkaConsumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			GroupID: groupId,
+		}),
+	}
+}
+
+func (c *KafkaConsumer) Fetch(ctx context.Context) (*Command, error) {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.last = msg
+		c.mu.Unlock()
+
+		var cmd Command
+		if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+			// a malformed message can never be processed, so it is skipped
+			if err := c.reader.CommitMessages(ctx, msg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return &cmd, nil
+	}
+}
+
+func (c *KafkaConsumer) Commit(ctx context.Context, cmd *Command) error {
+	c.mu.Lock()
+	msg := c.last
+	c.mu.Unlock()
+	return c.reader.CommitMessages(ctx, msg)
+}
+
+func (c *KafkaConsumer) Close() error {
+	return c.reader.Close()
+}
+
+// KafkaReplier writes replies to a topic, the key of a reply is the order id
+type KafkaReplier struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaReplier(brokers []string, topic string) *KafkaReplier {
+	return &KafkaReplier{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}
+}
+
+func (r *KafkaReplier) Reply(ctx context.Context, reply *Reply) error {
+	value, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return r.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(strconv.FormatUint(uint64(reply.OrderId), 10)),
+		Value: value,
+	})
+}
+
+func (r *KafkaReplier) Close() error {
+	return r.writer.Close()
+}
@@ -0,0 +1,34 @@
+package orders
+
+import cashaccount "user-balance-service/internal/cash_account"
+
+type CommandType string
+
+const (
+	CommandReserve CommandType = "reserve"
+	CommandAccept  CommandType = "accept"
+	CommandCancel  CommandType = "cancel"
+)
+
+// Command is a message from the order service
+type Command struct {
+	MessageId string      `json:"message_id"`
+	Type      CommandType `json:"type"`
+	cashaccount.ReserveDetails
+}
+
+type ReplyStatus string
+
+const (
+	ReplyOk    ReplyStatus = "ok"
+	ReplyError ReplyStatus = "error"
+)
+
+// Reply is sent back to the order service once the command is processed
+type Reply struct {
+	MessageId string      `json:"message_id"`
+	Type      CommandType `json:"type"`
+	OrderId   uint32      `json:"order_id"`
+	Status    ReplyStatus `json:"status"`
+	Error     string      `json:"error,omitempty"`
+}
@@ -0,0 +1,69 @@
+package orders
+
+import (
+	"context"
+	"sync"
+)
+
+// Consumer reads commands from a queue
+type Consumer interface {
+	// Fetch blocks until the next command is available
+	Fetch(ctx context.Context) (*Command, error)
+	// Commit acknowledges the last fetched command, it is called after the reply is published
+	Commit(ctx context.Context, cmd *Command) error
+	Close() error
+}
+
+// Replier publishes replies to the order service
+type Replier interface {
+	Reply(ctx context.Context, reply *Reply) error
+	Close() error
+}
+
+// MemoryQueue is an in-process queue of commands and replies, it is used in tests and development
+type MemoryQueue struct {
+	commands chan *Command
+
+	mu      sync.Mutex
+	replies []*Reply
+}
+
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{commands: make(chan *Command, size)}
+}
+
+func (q *MemoryQueue) Send(cmd *Command) {
+	q.commands <- cmd
+}
+
+func (q *MemoryQueue) Fetch(ctx context.Context) (*Command, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case cmd := <-q.commands:
+		return cmd, nil
+	}
+}
+
+func (q *MemoryQueue) Commit(ctx context.Context, cmd *Command) error {
+	return nil
+}
+
+func (q *MemoryQueue) Reply(ctx context.Context, reply *Reply) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.replies = append(q.replies, reply)
+	return nil
+}
+
+func (q *MemoryQueue) Replies() []*Reply {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := make([]*Reply, len(q.replies))
+	copy(res, q.replies)
+	return res
+}
+
+func (q *MemoryQueue) Close() error {
+	return nil
+}
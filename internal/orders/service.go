@@ -0,0 +1,157 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+// maxProcessAttempts is the number of attempts to process a command failed with an unexpected error,
+// after them the command is answered with an error so it does not block the partition
+const maxProcessAttempts = 5
+
+// Accounts is the part of the cash account service used by the commands
+type Accounts interface {
+	Reserve(ctx context.Context, data *cashaccount.ReserveDetails) error
+	// TryAcceptRevenue returns cashaccount.ErrUnreserved when the reserved money was returned instead
+	TryAcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails) error
+	CancelReservation(ctx context.Context, data *cashaccount.ReserveDetails) error
+}
+
+type Service struct {
+	storage  Storage
+	accounts Accounts
+	consumer Consumer
+	replier  Replier
+	logger   *logging.Logger
+
+	// failures counts unexpected errors of the commands, Process is called by one goroutine
+	failures map[string]int
+}
+
+func (s *Service) execute(ctx context.Context, cmd *Command) error {
+	switch cmd.Type {
+	case CommandReserve:
+		return s.accounts.Reserve(ctx, &cmd.ReserveDetails)
+	case CommandAccept:
+		return s.accounts.TryAcceptRevenue(ctx, &cmd.ReserveDetails)
+	case CommandCancel:
+		return s.accounts.CancelReservation(ctx, &cmd.ReserveDetails)
+	default:
+		return apperror.ErrBadRequest
+	}
+}
+
+// Process executes the command and publishes the reply. A command which was already
+// processed is not executed again, the saved reply is published instead.
+// Business errors are replied, other errors are returned and the message is not committed
+// until the command fails maxProcessAttempts times, then the error is replied
+func (s *Service) Process(ctx context.Context, cmd *Command) error {
+	if cmd.MessageId == "" {
+		s.logger.Errorf("Rejected %s command without message id for order %d", cmd.Type, cmd.OrderId)
+		return s.reply(ctx, cmd, &Reply{Type: cmd.Type, OrderId: cmd.OrderId, Status: ReplyError, Error: "message_id is required"})
+	}
+
+	reply, err := s.storage.GetReply(ctx, cmd.MessageId)
+	if err != nil {
+		return s.retry(ctx, cmd, err)
+	}
+
+	if reply == nil {
+		reply = &Reply{
+			MessageId: cmd.MessageId,
+			Type:      cmd.Type,
+			OrderId:   cmd.OrderId,
+			Status:    ReplyOk,
+		}
+		if err := s.execute(s.storage.WithReply(ctx, reply), cmd); err != nil {
+			var appErr *apperror.AppError
+			if !errors.As(err, &appErr) && !errors.Is(err, cashaccount.ErrUnreserved) {
+				// the change was rolled back, the message is processed again
+				return s.retry(ctx, cmd, err)
+			}
+			// an accept which returned the reserved money is saved with the same error reply
+			reply.Status = ReplyError
+			reply.Error = err.Error()
+		}
+		// the successful reply is usually saved with the change already
+		if err := s.storage.SaveReply(ctx, reply); err != nil {
+			return s.retry(ctx, cmd, err)
+		}
+	} else {
+		s.logger.Infof("Message %s is already processed, resending the reply", cmd.MessageId)
+	}
+
+	return s.reply(ctx, cmd, reply)
+}
+
+// retry returns the error so the command is processed again, after maxProcessAttempts
+// the command is answered with an error instead
+func (s *Service) retry(ctx context.Context, cmd *Command, err error) error {
+	s.failures[cmd.MessageId]++
+	if s.failures[cmd.MessageId] < maxProcessAttempts {
+		return err
+	}
+	s.logger.Errorf("Message %s failed %d times, last error: %s", cmd.MessageId, maxProcessAttempts, err)
+
+	reply, err := s.storage.GetReply(ctx, cmd.MessageId)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		reply = &Reply{
+			MessageId: cmd.MessageId,
+			Type:      cmd.Type,
+			OrderId:   cmd.OrderId,
+			Status:    ReplyError,
+			Error:     fmt.Sprintf("Command was not processed after %d attempts", maxProcessAttempts),
+		}
+		if err := s.storage.SaveReply(ctx, reply); err != nil {
+			return err
+		}
+	}
+	return s.reply(ctx, cmd, reply)
+}
+
+func (s *Service) reply(ctx context.Context, cmd *Command, reply *Reply) error {
+	if err := s.replier.Reply(ctx, reply); err != nil {
+		return err
+	}
+	if err := s.consumer.Commit(ctx, cmd); err != nil {
+		return err
+	}
+	delete(s.failures, cmd.MessageId)
+	return nil
+}
+
+// Run consumes commands until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	for {
+		cmd, err := s.consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Errorf("Error %s in fetching order command", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for {
+			err := s.Process(ctx, cmd)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			s.logger.Errorf("Error %s in processing message %s", err, cmd.MessageId)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func NewService(st Storage, accounts Accounts, consumer Consumer, replier Replier, logger *logging.Logger) *Service {
+	return &Service{st, accounts, consumer, replier, logger, make(map[string]int)}
+}
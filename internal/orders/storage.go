@@ -0,0 +1,14 @@
+package orders
+
+import "context"
+
+// Storage remembers replies to processed commands to deduplicate redelivered messages
+type Storage interface {
+	// GetReply returns nil if the message was not processed yet
+	GetReply(ctx context.Context, messageId string) (*Reply, error)
+	// SaveReply saves the reply unless the message is already processed
+	SaveReply(ctx context.Context, reply *Reply) error
+	// WithReply returns the context in which the reply is saved in the same transaction
+	// as the change of the accounts, the change fails if the message is already processed
+	WithReply(ctx context.Context, reply *Reply) context.Context
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/outbox"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+const relayLock = "outbox-relay"
+
+// Save writes the event to the outbox, it must be called in the transaction which changes the ledger
+func Save(tx *sql.Tx, e *events.Event) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`insert into outbox (service_user_id, event_type, payload) values (?, ?, ?);`, e.UserId, e.Type, payload)
+	return err
+}
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) Lock(ctx context.Context) (func(), bool, error) {
+	return mysql.TryLock(ctx, d.DB, relayLock)
+}
+
+func (d *db) GetUnpublished(ctx context.Context, limit uint32) ([]*outbox.Message, error) {
+	rows, err := d.QueryContext(ctx, `select id, service_user_id, event_type, payload, created_at from outbox where published_at is null order by id limit ?;`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*outbox.Message, 0)
+	for rows.Next() {
+		m := new(outbox.Message)
+		if err := rows.Scan(&m.ID, &m.UserId, &m.Type, &m.Payload, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) MarkPublished(ctx context.Context, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := d.ExecContext(ctx, `update outbox set published_at = now() where id in (`+placeholders+`);`, args...)
+	return err
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) outbox.Storage {
+	return &db{database, logger}
+}
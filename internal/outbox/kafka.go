@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes messages to a topic of a Kafka compatible broker.
+// The key of a message is the user id, so all events of a user go to one partition in order
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+			MaxAttempts:  3,
+		},
+	}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, msgs []*Message) error {
+	kmsgs := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		kmsgs = append(kmsgs, kafka.Message{
+			Key:   []byte(strconv.FormatUint(uint64(m.UserId), 10)),
+			Value: m.Payload,
+			Time:  m.CreatedAt,
+			Headers: []kafka.Header{
+				{Key: "event-type", Value: []byte(m.Type)},
+				{Key: "event-id", Value: []byte(strconv.FormatUint(m.ID, 10))},
+			},
+		})
+	}
+	return p.writer.WriteMessages(ctx, kmsgs...)
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,15 @@
+package outbox
+
+import (
+	"time"
+	"user-balance-service/internal/events"
+)
+
+// Message is an event saved to the outbox in the transaction which changed the ledger
+type Message struct {
+	ID        uint64      `json:"id"`
+	UserId    uint32      `json:"user_id"`
+	Type      events.Type `json:"type"`
+	Payload   []byte      `json:"payload"`
+	CreatedAt time.Time   `json:"created_at"`
+}
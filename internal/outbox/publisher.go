@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Publisher sends messages to the consumers. Messages of a batch are ordered by id and must be
+// published in that order. If an error is returned the whole batch is published again
+type Publisher interface {
+	Publish(ctx context.Context, msgs []*Message) error
+	Close() error
+}
+
+// MemoryPublisher keeps published messages in memory, it is used in tests and development
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, msgs []*Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *MemoryPublisher) Messages() []*Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]*Message, len(p.messages))
+	copy(res, p.messages)
+	return res
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
+
+// FilePublisher appends messages to a file as newline delimited JSON
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+type fileRecord struct {
+	ID        uint64          `json:"id"`
+	UserId    uint32          `json:"user_id"`
+	Type      string          `json:"type"`
+	Event     json.RawMessage `json:"event"`
+	CreatedAt string          `json:"created_at"`
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: f}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, msgs []*Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := bufio.NewWriter(p.file)
+	enc := json.NewEncoder(w)
+	for _, m := range msgs {
+		err := enc.Encode(&fileRecord{
+			ID:        m.ID,
+			UserId:    m.UserId,
+			Type:      string(m.Type),
+			Event:     m.Payload,
+			CreatedAt: m.CreatedAt.Format("2006-01-02T15:04:05.000Z07:00"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
+
+// MultiPublisher publishes every batch to all its publishers in order. A failed publisher makes
+// the relay publish the batch again to all of them, the consumers deduplicate messages by id
+type MultiPublisher struct {
+	publishers []Publisher
+}
+
+func NewMultiPublisher(publishers ...Publisher) *MultiPublisher {
+	return &MultiPublisher{publishers}
+}
+
+func (p *MultiPublisher) Publish(ctx context.Context, msgs []*Message) error {
+	for _, publisher := range p.publishers {
+		if err := publisher.Publish(ctx, msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MultiPublisher) Close() error {
+	var res error
+	for _, publisher := range p.publishers {
+		if err := publisher.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
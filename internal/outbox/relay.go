@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"time"
+	"user-balance-service/pkg/logging"
+)
+
+// Relay publishes the messages saved to the outbox. Messages are marked as published only
+// after the publisher accepted them, so the delivery is at least once and consumers
+// have to deduplicate them by id
+type Relay struct {
+	storage   Storage
+	publisher Publisher
+	batchSize uint32
+	logger    *logging.Logger
+}
+
+// Flush publishes unpublished messages until the outbox is empty
+func (r *Relay) Flush(ctx context.Context) error {
+	unlock, ok, err := r.storage.Lock(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	defer unlock()
+
+	for {
+		msgs, err := r.storage.GetUnpublished(ctx, r.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		if err := r.publisher.Publish(ctx, msgs); err != nil {
+			return err
+		}
+
+		ids := make([]uint64, 0, len(msgs))
+		for _, m := range msgs {
+			ids = append(ids, m.ID)
+		}
+		if err := r.storage.MarkPublished(ctx, ids); err != nil {
+			return err
+		}
+		r.logger.Infof("Published %d outbox messages, last id: %d", len(msgs), ids[len(ids)-1])
+
+		if uint32(len(msgs)) < r.batchSize {
+			return nil
+		}
+	}
+}
+
+// Run flushes the outbox until ctx is done
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				r.logger.Errorf("Error %s in publishing outbox messages", err)
+			}
+		}
+	}
+}
+
+func NewRelay(st Storage, publisher Publisher, batchSize uint32, logger *logging.Logger) *Relay {
+	return &Relay{st, publisher, batchSize, logger}
+}
@@ -0,0 +1,10 @@
+package outbox
+
+import "context"
+
+type Storage interface {
+	// Lock makes sure only one relay publishes messages at a time
+	Lock(ctx context.Context) (unlock func(), ok bool, err error)
+	GetUnpublished(ctx context.Context, limit uint32) ([]*Message, error)
+	MarkPublished(ctx context.Context, ids []uint64) error
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/events"
+	outboxdb "user-balance-service/internal/outbox/db"
+	"user-balance-service/internal/payment"
+	"user-balance-service/pkg/logging"
+)
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: 0})
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("Rollback error")
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *db) CreatePayment(ctx context.Context, p *payment.Payment) error {
+	var count int
+	err := d.QueryRow(`select count(id) from service_user where id = ?;`, p.UserId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return apperror.ErrNotFound
+	}
+
+	r, err := d.ExecContext(ctx, `insert into payment (service_user_id, amount, status, provider) values (?, ?, ?, ?);`, p.UserId, p.Amount, p.Status, p.Provider)
+	if err != nil {
+		d.logger.Errorf("Error %s in creating payment for user: %d amount: %f", err, p.UserId, p.Amount)
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.ID = uint32(id)
+	d.logger.Infof("Created payment %d for user: %d amount: %f", p.ID, p.UserId, p.Amount)
+	return d.QueryRow(`select created_at from payment where id = ?;`, p.ID).Scan(&p.CreatedAt)
+}
+
+func (d *db) SetProviderPayment(ctx context.Context, id uint32, pp *payment.ProviderPayment) error {
+	_, err := d.ExecContext(ctx, `update payment set provider_payment_id = ?, confirmation_url = ? where id = ?;`, pp.ID, pp.ConfirmationUrl, id)
+	return err
+}
+
+func (d *db) FailPayment(ctx context.Context, id uint32) error {
+	_, err := d.ExecContext(ctx, `update payment set status = ? where id = ? and status = ?;`, payment.StatusFailed, id, payment.StatusPending)
+	return err
+}
+
+func (d *db) CompletePayment(ctx context.Context, provider string, cb *payment.Callback) (*payment.Payment, bool, error) {
+	p := &payment.Payment{}
+	var completed bool
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRow(`select id, service_user_id, amount, status, provider, provider_payment_id, confirmation_url, created_at from payment where provider = ? and provider_payment_id = ? for update;`, provider, cb.ProviderPaymentId)
+		err := row.Scan(&p.ID, &p.UserId, &p.Amount, &p.Status, &p.Provider, &p.ProviderPaymentId, &p.ConfirmationUrl, &p.CreatedAt)
+		if err == sql.ErrNoRows {
+			return apperror.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// providers repeat callbacks, the payment is credited only once
+		if p.Status != payment.StatusPending {
+			return nil
+		}
+
+		_, err = tx.Exec(`update payment set status = ? where id = ?;`, cb.Status, p.ID)
+		if err != nil {
+			return err
+		}
+		p.Status = cb.Status
+		completed = true
+
+		if cb.Status != payment.StatusSucceeded {
+			return nil
+		}
+
+		err = cashaccountdb.TopUp(tx, p.UserId, p.Amount, &cashaccount.Operation{Type: cashaccount.OperationPayment, Direction: cashaccount.Credit, Reference: strconv.FormatUint(uint64(p.ID), 10)})
+		if err != nil {
+			return err
+		}
+
+		return outboxdb.Save(tx, &events.Event{Type: events.Accrual, UserId: p.UserId, Amount: p.Amount, Source: "payment"})
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in completing payment %s of provider %s", err, cb.ProviderPaymentId, provider)
+		return nil, false, err
+	}
+	d.logger.Infof("Payment %d of user: %d amount: %f is %s", p.ID, p.UserId, p.Amount, p.Status)
+	return p, completed, nil
+}
+
+func (d *db) GetPayment(ctx context.Context, id uint32) (*payment.Payment, error) {
+	p := &payment.Payment{}
+	row := d.QueryRow(`select id, service_user_id, amount, status, provider, provider_payment_id, confirmation_url, created_at from payment where id = ?;`, id)
+	err := row.Scan(&p.ID, &p.UserId, &p.Amount, &p.Status, &p.Provider, &p.ProviderPaymentId, &p.ConfirmationUrl, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) payment.Storage {
+	return &db{database, logger}
+}
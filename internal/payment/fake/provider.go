@@ -0,0 +1,162 @@
+// Package fake contains a payment provider which works locally.
+// Payments are confirmed by calling its confirmation url, after that the provider
+// sends a signed callback the same way a real payment system does
+package fake
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/internal/payment"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const SignatureHeader = "X-Signature"
+
+// sampleSecret was published in the example configuration, callbacks signed with it can be forged by anyone
+const sampleSecret = "fake-provider-secret"
+
+type Provider struct {
+	baseUrl     string
+	callbackUrl string
+	secret      []byte
+	client      *http.Client
+	logger      *logging.Logger
+
+	mu       sync.Mutex
+	payments map[string]*payment.Payment
+}
+
+func NewProvider(baseUrl, callbackUrl, secret string, logger *logging.Logger) (*Provider, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("Secret of payment callbacks is not set")
+	}
+	if secret == sampleSecret {
+		return nil, fmt.Errorf("Secret of payment callbacks is the published sample secret, set another one")
+	}
+	return &Provider{
+		baseUrl:     baseUrl,
+		callbackUrl: callbackUrl,
+		secret:      []byte(secret),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+		payments:    make(map[string]*payment.Payment),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return "fake"
+}
+
+func (p *Provider) CreatePayment(ctx context.Context, pm *payment.Payment) (*payment.ProviderPayment, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	p.payments[id] = pm
+	p.mu.Unlock()
+
+	return &payment.ProviderPayment{
+		ID:              id,
+		ConfirmationUrl: fmt.Sprintf("%s/fake-provider/payments/%s/confirm", p.baseUrl, id),
+	}, nil
+}
+
+// Sign returns the signature the provider puts into the callback headers
+func (p *Provider) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Provider) ParseCallback(r *http.Request) (*payment.Callback, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return nil, fmt.Errorf("Malformed callback signature")
+	}
+	expected, _ := hex.DecodeString(p.Sign(body))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("Invalid callback signature")
+	}
+
+	var cb payment.Callback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		return nil, err
+	}
+	return &cb, nil
+}
+
+// Complete finishes the payment with the status and notifies the service with the callback
+func (p *Provider) Complete(ctx context.Context, id string, status payment.Status) error {
+	p.mu.Lock()
+	_, ok := p.payments[id]
+	p.mu.Unlock()
+	if !ok {
+		return apperror.ErrNotFound
+	}
+
+	body, err := json.Marshal(&payment.Callback{ProviderPaymentId: id, Status: status})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, p.Sign(body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Callback for payment %s failed with status %d", id, resp.StatusCode)
+	}
+
+	p.mu.Lock()
+	delete(p.payments, id)
+	p.mu.Unlock()
+
+	p.logger.Infof("Fake provider completed payment %s with status %s", id, status)
+	return nil
+}
+
+func (p *Provider) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/fake-provider/payments/:id/confirm", middleware.Middleware(p.Confirm))
+}
+
+// Confirm emulates the user paying on the provider page. Pass status=failed to decline the payment
+func (p *Provider) Confirm(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	status := payment.StatusSucceeded
+	if r.URL.Query().Get("status") == string(payment.StatusFailed) {
+		status = payment.StatusFailed
+	}
+
+	return p.Complete(r.Context(), params.ByName("id"), status)
+}
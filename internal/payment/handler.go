@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	logger  *logging.Logger
+}
+
+func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/users/payments/", middleware.Middleware(h.CreateTopUp))
+	router.HandlerFunc(http.MethodGet, "/api/users/payments/:id", middleware.Middleware(h.GetPayment))
+	router.HandlerFunc(http.MethodPost, "/api/payments/callback", middleware.Middleware(h.Callback))
+}
+
+func (h *handler) CreateTopUp(w http.ResponseWriter, r *http.Request) error {
+	var data TopUpRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	p, err := h.service.CreateTopUp(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(p)
+	return nil
+}
+
+func (h *handler) GetPayment(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id <= 0 {
+		return apperror.ErrBadRequest
+	}
+
+	p, err := h.service.GetPayment(context.Background(), uint32(id))
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(p)
+	return nil
+}
+
+func (h *handler) Callback(w http.ResponseWriter, r *http.Request) error {
+	_, err := h.service.HandleCallback(context.Background(), r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
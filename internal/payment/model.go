@@ -0,0 +1,39 @@
+package payment
+
+import "time"
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+type TopUpRequest struct {
+	ID     uint32  `json:"id"`
+	Amount float32 `json:"amount"`
+}
+
+type Payment struct {
+	ID                uint32    `json:"payment_id"`
+	UserId            uint32    `json:"id"`
+	Amount            float32   `json:"amount"`
+	Status            Status    `json:"status"`
+	Provider          string    `json:"provider"`
+	ProviderPaymentId string    `json:"provider_payment_id,omitempty"`
+	ConfirmationUrl   string    `json:"confirmation_url,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ProviderPayment is a payment registered on the provider side
+type ProviderPayment struct {
+	ID              string
+	ConfirmationUrl string
+}
+
+// Callback is a verified notification from the provider about the payment result
+type Callback struct {
+	ProviderPaymentId string `json:"payment_id"`
+	Status            Status `json:"status"`
+}
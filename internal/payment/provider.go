@@ -0,0 +1,14 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+)
+
+// Provider is a payment system which accepts money from the user and reports the result with a signed callback
+type Provider interface {
+	Name() string
+	CreatePayment(ctx context.Context, p *Payment) (*ProviderPayment, error)
+	// ParseCallback verifies the signature of the callback request and decodes it
+	ParseCallback(r *http.Request) (*Callback, error)
+}
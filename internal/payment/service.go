@@ -0,0 +1,77 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/pkg/logging"
+)
+
+type Service struct {
+	storage  Storage
+	provider Provider
+	logger   *logging.Logger
+	bus      *events.Bus
+}
+
+func (s *Service) CreateTopUp(ctx context.Context, data *TopUpRequest) (*Payment, error) {
+	if data.Amount <= 0 || data.ID <= 0 {
+		return nil, apperror.ErrBadRequest
+	}
+
+	p := &Payment{
+		UserId:   data.ID,
+		Amount:   data.Amount,
+		Status:   StatusPending,
+		Provider: s.provider.Name(),
+	}
+	if err := s.storage.CreatePayment(ctx, p); err != nil {
+		return nil, err
+	}
+
+	pp, err := s.provider.CreatePayment(ctx, p)
+	if err != nil {
+		s.logger.Errorf("Provider %s failed to create payment %d: %s", p.Provider, p.ID, err)
+		if err := s.storage.FailPayment(ctx, p.ID); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	if err := s.storage.SetProviderPayment(ctx, p.ID, pp); err != nil {
+		return nil, err
+	}
+	p.ProviderPaymentId = pp.ID
+	p.ConfirmationUrl = pp.ConfirmationUrl
+
+	return p, nil
+}
+
+// HandleCallback credits the main account once the provider confirms the payment
+func (s *Service) HandleCallback(ctx context.Context, r *http.Request) (*Payment, error) {
+	cb, err := s.provider.ParseCallback(r)
+	if err != nil {
+		s.logger.Errorf("Rejected callback from provider %s: %s", s.provider.Name(), err)
+		return nil, apperror.ErrBadRequest
+	}
+	if cb.Status != StatusSucceeded && cb.Status != StatusFailed {
+		return nil, apperror.ErrBadRequest
+	}
+	p, completed, err := s.storage.CompletePayment(ctx, s.provider.Name(), cb)
+	if err != nil {
+		return nil, err
+	}
+	if completed && p.Status == StatusSucceeded {
+		s.bus.Publish(ctx, &events.Event{Type: events.Accrual, UserId: p.UserId, Amount: p.Amount, Source: "payment"})
+	}
+	return p, nil
+}
+
+func (s *Service) GetPayment(ctx context.Context, id uint32) (*Payment, error) {
+	return s.storage.GetPayment(ctx, id)
+}
+
+func NewService(st Storage, provider Provider, logger *logging.Logger, bus *events.Bus) *Service {
+	return &Service{st, provider, logger, bus}
+}
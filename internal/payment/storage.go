@@ -0,0 +1,12 @@
+package payment
+
+import "context"
+
+type Storage interface {
+	CreatePayment(ctx context.Context, p *Payment) error
+	SetProviderPayment(ctx context.Context, id uint32, pp *ProviderPayment) error
+	FailPayment(ctx context.Context, id uint32) error
+	// CompletePayment applies the callback once, the returned flag is false for repeated callbacks
+	CompletePayment(ctx context.Context, provider string, cb *Callback) (*Payment, bool, error)
+	GetPayment(ctx context.Context, id uint32) (*Payment, error)
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/events"
+	outboxdb "user-balance-service/internal/outbox/db"
+	"user-balance-service/internal/payout"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+const pollLock = "payout-poll"
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) Lock(ctx context.Context) (func(), bool, error) {
+	return mysql.TryLock(ctx, d.DB, pollLock)
+}
+
+func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: 0})
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("Rollback error")
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *db) Hold(ctx context.Context, p *payout.Payout) error {
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		var balance float32
+		row := tx.QueryRow(`select balance from main_account where service_user_id = ? for update;`, p.UserId)
+		err := row.Scan(&balance)
+		if err == sql.ErrNoRows {
+			return apperror.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if balance < p.Amount {
+			return fmt.Errorf("User %d has insufficient funds", p.UserId)
+		}
+
+		_, err = tx.Exec(`update main_account set balance = balance - ? where service_user_id = ?;`, p.Amount, p.UserId)
+		if err != nil {
+			return err
+		}
+
+		r, err := tx.Exec(`insert into payout (service_user_id, amount, destination, status, gateway) values (?, ?, ?, ?, ?);`, p.UserId, p.Amount, p.Destination, p.Status, p.Gateway)
+		if err != nil {
+			return err
+		}
+		id, err := r.LastInsertId()
+		if err != nil {
+			return err
+		}
+		p.ID = uint32(id)
+
+		err = cashaccountdb.UpdateUserReport(tx, p.UserId, p.Amount, &cashaccount.Operation{Type: cashaccount.OperationPayoutHold, Direction: cashaccount.Debit, Reference: strconv.FormatUint(uint64(p.ID), 10)})
+		if err != nil {
+			return err
+		}
+
+		return outboxdb.Save(tx, &events.Event{Type: events.Withdraw, UserId: p.UserId, Amount: p.Amount, Source: "payout"})
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in holding payout for user: %d amount: %f", err, p.UserId, p.Amount)
+		return err
+	}
+	d.logger.Infof("Held payout %d for user: %d amount: %f", p.ID, p.UserId, p.Amount)
+	return d.QueryRow(`select created_at, updated_at from payout where id = ?;`, p.ID).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
+
+func (d *db) MarkProcessing(ctx context.Context, id uint32, ref string) error {
+	_, err := d.ExecContext(ctx, `update payout set status = ?, gateway_ref = ? where id = ? and status in (?, ?);`, payout.StatusProcessing, ref, id, payout.StatusPending, payout.StatusProcessing)
+	return err
+}
+
+func (d *db) MarkResent(ctx context.Context, id uint32, ref string) error {
+	_, err := d.ExecContext(ctx, `update payout set gateway_ref = ?, resent = true where id = ? and status = ?;`, ref, id, payout.StatusProcessing)
+	return err
+}
+
+func (d *db) RecordAttempt(ctx context.Context, id uint32, retryAt time.Time) (uint32, error) {
+	if _, err := d.ExecContext(ctx, `update payout set attempts = attempts + 1, next_poll_at = ? where id = ?;`, retryAt, id); err != nil {
+		return 0, err
+	}
+	var attempts uint32
+	err := d.QueryRowContext(ctx, `select attempts from payout where id = ?;`, id).Scan(&attempts)
+	return attempts, err
+}
+
+func (d *db) Finalize(ctx context.Context, id uint32) error {
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		var userId uint32
+		var amount float32
+		var status payout.Status
+		row := tx.QueryRow(`select service_user_id, amount, status from payout where id = ? for update;`, id)
+		if err := row.Scan(&userId, &amount, &status); err != nil {
+			return err
+		}
+		if status == payout.StatusSucceeded || status == payout.StatusFailed {
+			return nil
+		}
+
+		_, err := tx.Exec(`update payout set status = ? where id = ?;`, payout.StatusSucceeded, id)
+		if err != nil {
+			return err
+		}
+
+		return cashaccountdb.UpdateUserReport(tx, userId, amount, &cashaccount.Operation{Type: cashaccount.OperationPayout, Direction: cashaccount.None, Reference: strconv.FormatUint(uint64(id), 10)})
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in finalizing payout %d", err, id)
+	} else {
+		d.logger.Infof("Finalized payout %d", id)
+	}
+	return err
+}
+
+// Fail returns the held money to the main account
+func (d *db) Fail(ctx context.Context, id uint32) (bool, error) {
+	var failed bool
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		var userId uint32
+		var amount float32
+		var status payout.Status
+		row := tx.QueryRow(`select service_user_id, amount, status from payout where id = ? for update;`, id)
+		if err := row.Scan(&userId, &amount, &status); err != nil {
+			return err
+		}
+		if status == payout.StatusSucceeded || status == payout.StatusFailed {
+			return nil
+		}
+
+		_, err := tx.Exec(`update payout set status = ? where id = ?;`, payout.StatusFailed, id)
+		if err != nil {
+			return err
+		}
+
+		failed = true
+		err = cashaccountdb.TopUp(tx, userId, amount, &cashaccount.Operation{Type: cashaccount.OperationPayoutReturn, Direction: cashaccount.Credit, Reference: strconv.FormatUint(uint64(id), 10)})
+		if err != nil {
+			return err
+		}
+
+		return outboxdb.Save(tx, &events.Event{Type: events.Refund, UserId: userId, Amount: amount, Source: "payout"})
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in failing payout %d", err, id)
+	} else {
+		d.logger.Infof("Failed payout %d, money returned", id)
+	}
+	return failed, err
+}
+
+func (d *db) GetUnfinished(ctx context.Context, now time.Time, limit uint32) ([]*payout.Payout, error) {
+	rows, err := d.QueryContext(ctx, `select id, service_user_id, amount, destination, status, gateway, gateway_ref, attempts, resent, created_at, updated_at from payout
+		where status in (?, ?) and (next_poll_at is null or next_poll_at <= ?) order by id limit ?;`, payout.StatusPending, payout.StatusProcessing, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*payout.Payout, 0)
+	for rows.Next() {
+		p := new(payout.Payout)
+		if err := rows.Scan(&p.ID, &p.UserId, &p.Amount, &p.Destination, &p.Status, &p.Gateway, &p.GatewayRef, &p.Attempts, &p.Resent, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) GetPayout(ctx context.Context, id uint32) (*payout.Payout, error) {
+	p := new(payout.Payout)
+	row := d.QueryRow(`select id, service_user_id, amount, destination, status, gateway, gateway_ref, attempts, resent, created_at, updated_at from payout where id = ?;`, id)
+	err := row.Scan(&p.ID, &p.UserId, &p.Amount, &p.Destination, &p.Status, &p.Gateway, &p.GatewayRef, &p.Attempts, &p.Resent, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) payout.Storage {
+	return &db{database, logger}
+}
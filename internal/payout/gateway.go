@@ -0,0 +1,13 @@
+package payout
+
+import "context"
+
+// Gateway sends money to cards or bank accounts. Payouts are processed asynchronously,
+// so the result is fetched later with Status
+type Gateway interface {
+	Name() string
+	// Send must be idempotent by the payout id, it returns the reference of the payout in the gateway
+	Send(ctx context.Context, p *Payout) (string, error)
+	// Status returns apperror.ErrNotFound if the gateway does not know the payout, e.g. it lost it on restart
+	Status(ctx context.Context, ref string) (Status, error)
+}
@@ -0,0 +1,64 @@
+package payout
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	logger  *logging.Logger
+}
+
+func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/users/payouts/", middleware.Middleware(h.RequestPayout))
+	router.HandlerFunc(http.MethodGet, "/api/users/payouts/:id", middleware.Middleware(h.GetPayout))
+}
+
+func (h *handler) RequestPayout(w http.ResponseWriter, r *http.Request) error {
+	var data Request
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	p, err := h.service.RequestPayout(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(p)
+	return nil
+}
+
+func (h *handler) GetPayout(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id <= 0 {
+		return apperror.ErrBadRequest
+	}
+
+	p, err := h.service.GetPayout(context.Background(), uint32(id))
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(p)
+	return nil
+}
@@ -0,0 +1,35 @@
+package payout
+
+import "time"
+
+type Status string
+
+const (
+	// StatusPending means the money is held but the payout is not yet accepted by the gateway
+	StatusPending    Status = "pending"
+	StatusProcessing Status = "processing"
+	StatusSucceeded  Status = "succeeded"
+	StatusFailed     Status = "failed"
+)
+
+type Request struct {
+	ID          uint32  `json:"id"`
+	Amount      float32 `json:"amount"`
+	Destination string  `json:"destination"`
+}
+
+type Payout struct {
+	ID          uint32  `json:"payout_id"`
+	UserId      uint32  `json:"id"`
+	Amount      float32 `json:"amount"`
+	Destination string  `json:"destination"`
+	Status      Status  `json:"status"`
+	Gateway     string  `json:"gateway"`
+	GatewayRef  string  `json:"gateway_ref,omitempty"`
+	// Attempts is the number of failed status requests
+	Attempts uint32 `json:"-"`
+	// Resent is set when the payout was sent again because the gateway did not know it
+	Resent    bool      `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
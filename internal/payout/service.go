@@ -0,0 +1,194 @@
+package payout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/pkg/logging"
+)
+
+const pollBatchSize = 100
+
+// alertStatusAttempts is the number of failed status requests after which the payout
+// has to be checked manually. It is not failed: the money may be already sent
+const alertStatusAttempts = 10
+
+// baseStatusBackoff doubles after every failed status request up to maxStatusBackoff
+const (
+	baseStatusBackoff = 10 * time.Second
+	maxStatusBackoff  = time.Hour
+)
+
+type Service struct {
+	storage Storage
+	gateway Gateway
+	logger  *logging.Logger
+	bus     *events.Bus
+}
+
+// RequestPayout holds the amount on the user account and sends the payout to the gateway
+func (s *Service) RequestPayout(ctx context.Context, data *Request) (*Payout, error) {
+	data.Destination = strings.TrimSpace(data.Destination)
+	if data.Amount <= 0 || data.ID <= 0 {
+		return nil, apperror.ErrBadRequest
+	}
+	if data.Destination == "" || len(data.Destination) > 255 {
+		return nil, apperror.ErrBadRequest
+	}
+
+	p := &Payout{
+		UserId:      data.ID,
+		Amount:      data.Amount,
+		Destination: data.Destination,
+		Status:      StatusPending,
+		Gateway:     s.gateway.Name(),
+	}
+	if err := s.storage.Hold(ctx, p); err != nil {
+		return nil, err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Withdraw, UserId: p.UserId, Amount: p.Amount, Source: "payout"})
+
+	if err := s.send(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Service) send(ctx context.Context, p *Payout) error {
+	ref, err := s.gateway.Send(ctx, p)
+	if err != nil {
+		s.logger.Errorf("Gateway %s rejected payout %d: %s", p.Gateway, p.ID, err)
+		if err := s.fail(ctx, p); err != nil {
+			return err
+		}
+		p.Status = StatusFailed
+		return nil
+	}
+
+	if err := s.storage.MarkProcessing(ctx, p.ID, ref); err != nil {
+		return err
+	}
+	p.Status = StatusProcessing
+	p.GatewayRef = ref
+	return nil
+}
+
+func (s *Service) fail(ctx context.Context, p *Payout) error {
+	failed, err := s.storage.Fail(ctx, p.ID)
+	if err != nil {
+		return err
+	}
+	if failed {
+		s.bus.Publish(ctx, &events.Event{Type: events.Refund, UserId: p.UserId, Amount: p.Amount, Source: "payout"})
+	}
+	return nil
+}
+
+func (s *Service) GetPayout(ctx context.Context, id uint32) (*Payout, error) {
+	return s.storage.GetPayout(ctx, id)
+}
+
+// Poll fetches the results of unfinished payouts from the gateway.
+// Payouts which were held but not sent (e.g. the service stopped in between) are sent again
+func (s *Service) Poll(ctx context.Context) error {
+	unlock, ok, err := s.storage.Lock(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	defer unlock()
+
+	payouts, err := s.storage.GetUnfinished(ctx, time.Now(), pollBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payouts {
+		if p.Status == StatusPending {
+			if err := s.send(ctx, p); err != nil {
+				s.logger.Errorf("Error %s in sending payout %d", err, p.ID)
+			}
+			continue
+		}
+
+		status, err := s.gateway.Status(ctx, p.GatewayRef)
+		if err != nil {
+			s.logger.Errorf("Error %s in getting status of payout %d", err, p.ID)
+			if err := s.retry(ctx, p, err); err != nil {
+				s.logger.Errorf("Error %s in retrying payout %d", err, p.ID)
+			}
+			continue
+		}
+
+		switch status {
+		case StatusSucceeded:
+			err = s.storage.Finalize(ctx, p.ID)
+		case StatusFailed:
+			err = s.fail(ctx, p)
+		}
+		if err != nil {
+			s.logger.Errorf("Error %s in updating payout %d", err, p.ID)
+		}
+	}
+	return nil
+}
+
+// retry handles a failed status request. A payout unknown to the gateway is sent again,
+// which is safe because Send is idempotent, and it fails only if the gateway does not know it
+// after that. Other errors say nothing about the payout, so it is polled again with backoff
+func (s *Service) retry(ctx context.Context, p *Payout, statusErr error) error {
+	if errors.Is(statusErr, apperror.ErrNotFound) {
+		if p.Resent {
+			s.logger.Errorf("Gateway %s does not know payout %d sent again", p.Gateway, p.ID)
+			return s.fail(ctx, p)
+		}
+		ref, err := s.gateway.Send(ctx, p)
+		if err == nil {
+			s.logger.Infof("Sent payout %d again to %s", p.ID, p.Gateway)
+			return s.storage.MarkResent(ctx, p.ID, ref)
+		}
+		s.logger.Errorf("Error %s in sending payout %d again to %s", err, p.ID, p.Gateway)
+	}
+
+	attempts, err := s.storage.RecordAttempt(ctx, p.ID, time.Now().Add(statusBackoff(p.Attempts+1)))
+	if err != nil {
+		return err
+	}
+	if attempts >= alertStatusAttempts {
+		s.logger.Errorf("Status of payout %d is unknown after %d requests to %s, check it manually", p.ID, attempts, p.Gateway)
+	}
+	return nil
+}
+
+func statusBackoff(attempts uint32) time.Duration {
+	backoff := baseStatusBackoff
+	for i := uint32(1); i < attempts && backoff < maxStatusBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxStatusBackoff {
+		return maxStatusBackoff
+	}
+	return backoff
+}
+
+// Run polls the gateway until ctx is done
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Poll(ctx); err != nil {
+				s.logger.Errorf("Error %s in polling payouts", err)
+			}
+		}
+	}
+}
+
+func NewService(st Storage, gateway Gateway, logger *logging.Logger, bus *events.Bus) *Service {
+	return &Service{st, gateway, logger, bus}
+}
@@ -0,0 +1,73 @@
+// Package simulator contains a payout gateway which works locally.
+// A payout is processed for the configured delay, then it succeeds unless its destination
+// contains "fail" or it is randomly declined according to the failure rate
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/payout"
+)
+
+type transfer struct {
+	readyAt time.Time
+	result  payout.Status
+}
+
+type Gateway struct {
+	delay       time.Duration
+	failureRate float64
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+}
+
+func NewGateway(delay time.Duration, failureRate float64) *Gateway {
+	return &Gateway{
+		delay:       delay,
+		failureRate: failureRate,
+		transfers:   make(map[string]*transfer),
+	}
+}
+
+func (g *Gateway) Name() string {
+	return "simulator"
+}
+
+func (g *Gateway) Send(ctx context.Context, p *payout.Payout) (string, error) {
+	ref := fmt.Sprintf("sim-%d", p.ID)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.transfers[ref]; ok {
+		return ref, nil
+	}
+
+	result := payout.StatusSucceeded
+	if strings.Contains(strings.ToLower(p.Destination), "fail") || rand.Float64() < g.failureRate {
+		result = payout.StatusFailed
+	}
+	g.transfers[ref] = &transfer{
+		readyAt: time.Now().Add(g.delay),
+		result:  result,
+	}
+	return ref, nil
+}
+
+func (g *Gateway) Status(ctx context.Context, ref string) (payout.Status, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t, ok := g.transfers[ref]
+	if !ok {
+		return "", apperror.ErrNotFound
+	}
+	if time.Now().Before(t.readyAt) {
+		return payout.StatusProcessing, nil
+	}
+	return t.result, nil
+}
@@ -0,0 +1,25 @@
+package payout
+
+import (
+	"context"
+	"time"
+)
+
+type Storage interface {
+	// Lock makes sure only one replica polls the gateway at a time
+	Lock(ctx context.Context) (unlock func(), ok bool, err error)
+	Hold(ctx context.Context, p *Payout) error
+	// MarkProcessing saves the reference of a pending or processing payout
+	MarkProcessing(ctx context.Context, id uint32, ref string) error
+	// MarkResent saves the reference of a payout sent again after the gateway lost it
+	MarkResent(ctx context.Context, id uint32, ref string) error
+	// RecordAttempt counts a failed status request of the payout, postpones its next poll till retryAt
+	// and returns the number of failed requests
+	RecordAttempt(ctx context.Context, id uint32, retryAt time.Time) (uint32, error)
+	Finalize(ctx context.Context, id uint32) error
+	// Fail returns the held money, the returned flag is false if the payout was already finished
+	Fail(ctx context.Context, id uint32) (bool, error)
+	// GetUnfinished returns pending and processing payouts which are due to be polled at now
+	GetUnfinished(ctx context.Context, now time.Time, limit uint32) ([]*Payout, error)
+	GetPayout(ctx context.Context, id uint32) (*Payout, error)
+}
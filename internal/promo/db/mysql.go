@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	cashaccountdb "user-balance-service/internal/cash_account/db"
+	"user-balance-service/internal/events"
+	outboxdb "user-balance-service/internal/outbox/db"
+	"user-balance-service/internal/promo"
+	"user-balance-service/pkg/logging"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const errDuplicateEntry = 1062
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) execWithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: 0})
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("Rollback error")
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *db) CreatePromoCode(ctx context.Context, code *promo.PromoCode) error {
+	r, err := d.ExecContext(ctx, `insert into promo_code (code, amount, usage_limit, per_user_limit, valid_from, valid_to) values (?, ?, ?, ?, ?, ?);`,
+		code.Code, code.Amount, code.UsageLimit, code.PerUserLimit, code.ValidFrom, code.ValidTo)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+			return apperror.ErrConflict
+		}
+		d.logger.Errorf("Error %s in creating promo code %s", err, code.Code)
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	code.ID = uint32(id)
+	d.logger.Infof("Created promo code %s amount: %f usage limit: %d", code.Code, code.Amount, code.UsageLimit)
+	return nil
+}
+
+func (d *db) Redeem(ctx context.Context, data *promo.Redemption) (float32, error) {
+	var amount float32
+	err := d.execWithTx(ctx, func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow(`select count(id) from service_user where id = ?;`, data.ID).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return apperror.ErrNotFound
+		}
+
+		// the row lock serializes concurrent redemptions of the same code
+		var code promo.PromoCode
+		row := tx.QueryRow(`select id, amount, usage_limit, per_user_limit, used_count, valid_from, valid_to from promo_code where code = ? for update;`, data.Code)
+		err := row.Scan(&code.ID, &code.Amount, &code.UsageLimit, &code.PerUserLimit, &code.UsedCount, &code.ValidFrom, &code.ValidTo)
+		if err == sql.ErrNoRows {
+			return apperror.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.Before(code.ValidFrom) || !now.Before(code.ValidTo) {
+			return apperror.ErrConflict
+		}
+		if code.UsedCount >= code.UsageLimit {
+			return apperror.ErrConflict
+		}
+
+		var redeemed uint32
+		row = tx.QueryRow(`select count(id) from promo_redemption where promo_code_id = ? and service_user_id = ?;`, code.ID, data.ID)
+		if err := row.Scan(&redeemed); err != nil {
+			return err
+		}
+		if redeemed >= code.PerUserLimit {
+			return apperror.ErrConflict
+		}
+
+		_, err = tx.Exec(`insert into promo_redemption (promo_code_id, service_user_id, amount) values (?, ?, ?);`, code.ID, data.ID, code.Amount)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`update promo_code set used_count = used_count + 1 where id = ?;`, code.ID)
+		if err != nil {
+			return err
+		}
+
+		amount = code.Amount
+		err = cashaccountdb.TopUp(tx, data.ID, code.Amount, &cashaccount.Operation{Type: cashaccount.OperationPromo, Direction: cashaccount.Credit, Reference: data.Code})
+		if err != nil {
+			return err
+		}
+
+		return outboxdb.Save(tx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: code.Amount, Source: "promo"})
+	})
+	if err != nil {
+		d.logger.Errorf("Error %s in redeeming promo code %s by user: %d", err, data.Code, data.ID)
+		return 0, err
+	}
+	d.logger.Infof("Redeemed promo code %s by user: %d", data.Code, data.ID)
+	return amount, nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) promo.Storage {
+	return &db{database, logger}
+}
@@ -0,0 +1,63 @@
+package promo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+)
+
+type handler struct {
+	service *Service
+	admin   *middleware.Token
+	logger  *logging.Logger
+}
+
+// NewHandler creates the handler, promo codes are created only with the admin token
+func NewHandler(service *Service, admin *middleware.Token, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		admin:   admin,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/promo/", middleware.Middleware(h.admin.Admin(h.CreatePromoCode)))
+	router.HandlerFunc(http.MethodPost, "/api/users/promo/redeem", middleware.Middleware(h.Redeem))
+}
+
+func (h *handler) CreatePromoCode(w http.ResponseWriter, r *http.Request) error {
+	var data PromoCode
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	err = h.service.CreatePromoCode(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(data)
+	return nil
+}
+
+func (h *handler) Redeem(w http.ResponseWriter, r *http.Request) error {
+	var data Redemption
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	err = h.service.Redeem(context.Background(), &data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package promo
+
+import "time"
+
+type PromoCode struct {
+	ID           uint32    `json:"-"`
+	Code         string    `json:"code"`
+	Amount       float32   `json:"amount"`
+	UsageLimit   uint32    `json:"usage_limit"`
+	PerUserLimit uint32    `json:"per_user_limit"`
+	UsedCount    uint32    `json:"used_count"`
+	ValidFrom    time.Time `json:"valid_from"`
+	ValidTo      time.Time `json:"valid_to"`
+}
+
+type Redemption struct {
+	ID   uint32 `json:"id"`
+	Code string `json:"code"`
+}
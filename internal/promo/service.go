@@ -0,0 +1,58 @@
+package promo
+
+import (
+	"context"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/pkg/logging"
+)
+
+type Service struct {
+	storage Storage
+	logger  *logging.Logger
+	bus     *events.Bus
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (s *Service) CreatePromoCode(ctx context.Context, data *PromoCode) error {
+	data.Code = normalizeCode(data.Code)
+	if data.Code == "" || len(data.Code) > 50 {
+		return apperror.ErrBadRequest
+	}
+	if data.Amount <= 0 || data.UsageLimit == 0 || data.PerUserLimit == 0 {
+		return apperror.ErrBadRequest
+	}
+	if data.PerUserLimit > data.UsageLimit {
+		return apperror.ErrBadRequest
+	}
+	if data.ValidFrom.IsZero() {
+		data.ValidFrom = time.Now()
+	}
+	if !data.ValidTo.After(data.ValidFrom) {
+		return apperror.ErrBadRequest
+	}
+	data.UsedCount = 0
+	return s.storage.CreatePromoCode(ctx, data)
+}
+
+func (s *Service) Redeem(ctx context.Context, data *Redemption) error {
+	data.Code = normalizeCode(data.Code)
+	if data.ID <= 0 || data.Code == "" {
+		return apperror.ErrBadRequest
+	}
+	amount, err := s.storage.Redeem(ctx, data)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(ctx, &events.Event{Type: events.Accrual, UserId: data.ID, Amount: amount, Source: "promo"})
+	return nil
+}
+
+func NewService(st Storage, logger *logging.Logger, bus *events.Bus) *Service {
+	return &Service{st, logger, bus}
+}
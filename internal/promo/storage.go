@@ -0,0 +1,9 @@
+package promo
+
+import "context"
+
+type Storage interface {
+	CreatePromoCode(ctx context.Context, code *PromoCode) error
+	// Redeem credits the amount of the promo code to the user and returns it
+	Redeem(ctx context.Context, data *Redemption) (float32, error)
+}
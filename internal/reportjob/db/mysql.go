@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/reportjob"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+)
+
+const errDuplicateEntry = 1062
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+// Create relies on the unique active_key column, which holds the parameters of
+// an unfinished job and is cleared when the job finishes
+func (d *db) Create(ctx context.Context, job *reportjob.Job, paramsKey string) (*reportjob.Job, bool, error) {
+	r, err := d.ExecContext(ctx, `insert into report_job (period, date_from, date_to, granularity, format, created_by, status, active_key) values (?, ?, ?, ?, ?, ?, ?, ?);`,
+		job.Period, job.From, job.To, job.Granularity, job.Format, job.Creator, job.Status, paramsKey)
+	if err != nil {
+		var mysqlErr *mysqlDriver.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+			existing, err := d.scan(d.QueryRowContext(ctx, `select `+jobColumns+` from report_job where active_key = ?;`, paramsKey))
+			if err == sql.ErrNoRows {
+				// the job has just finished, a new one can be created
+				return d.Create(ctx, job, paramsKey)
+			}
+			return existing, false, err
+		}
+		d.logger.Errorf("Error %s in creating report job for %s", err, paramsKey)
+		return nil, false, err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := d.Get(ctx, uint32(id))
+	return res, true, err
+}
+
+const jobColumns = `id, period, date_from, date_to, granularity, format, created_by, status, report_key, error, created_at, updated_at`
+
+func (d *db) scan(row *sql.Row) (*reportjob.Job, error) {
+	job := new(reportjob.Job)
+	err := row.Scan(&job.ID, &job.Period, &job.From, &job.To, &job.Granularity, &job.Format, &job.Creator, &job.Status, &job.ReportKey, &job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (d *db) Get(ctx context.Context, id uint32) (*reportjob.Job, error) {
+	job, err := d.scan(d.QueryRowContext(ctx, `select `+jobColumns+` from report_job where id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	return job, err
+}
+
+func (d *db) GetQueued(ctx context.Context, limit uint32) ([]uint32, error) {
+	rows, err := d.QueryContext(ctx, `select id from report_job where status = ? order by id limit ?;`, reportjob.StatusQueued, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]uint32, 0)
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) Claim(ctx context.Context, id uint32) (bool, error) {
+	r, err := d.ExecContext(ctx, `update report_job set status = ?, attempts = attempts + 1, updated_at = current_timestamp where id = ? and status = ?;`,
+		reportjob.StatusRunning, id, reportjob.StatusQueued)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	return affected == 1, err
+}
+
+func (d *db) Heartbeat(ctx context.Context, id uint32) error {
+	_, err := d.ExecContext(ctx, `update report_job set updated_at = current_timestamp where id = ? and status = ?;`, id, reportjob.StatusRunning)
+	return err
+}
+
+func (d *db) Expire(ctx context.Context, lease time.Duration, maxAttempts uint32) error {
+	seconds := int64(lease / time.Second)
+	r, err := d.ExecContext(ctx, `update report_job set status = ?, error = 'The worker stopped while building the report', active_key = null
+		where status = ? and updated_at < current_timestamp - interval ? second and attempts >= ?;`, reportjob.StatusFailed, reportjob.StatusRunning, seconds, maxAttempts)
+	if err != nil {
+		return err
+	}
+	if failed, _ := r.RowsAffected(); failed > 0 {
+		d.logger.Errorf("Failed %d report jobs after %d stopped workers", failed, maxAttempts)
+	}
+
+	r, err = d.ExecContext(ctx, `update report_job set status = ? where status = ? and updated_at < current_timestamp - interval ? second;`,
+		reportjob.StatusQueued, reportjob.StatusRunning, seconds)
+	if err != nil {
+		return err
+	}
+	if requeued, _ := r.RowsAffected(); requeued > 0 {
+		d.logger.Infof("Requeued %d report jobs of stopped workers", requeued)
+	}
+	return nil
+}
+
+func (d *db) Finish(ctx context.Context, id uint32, reportKey string) error {
+	_, err := d.ExecContext(ctx, `update report_job set status = ?, report_key = ?, active_key = null where id = ?;`, reportjob.StatusDone, reportKey, id)
+	return err
+}
+
+func (d *db) Fail(ctx context.Context, id uint32, reason string) error {
+	_, err := d.ExecContext(ctx, `update report_job set status = ?, error = ?, active_key = null where id = ?;`, reportjob.StatusFailed, reason, id)
+	return err
+}
+
+// scheduleLock is taken by the replica which generates the scheduled report
+const scheduleLock = "scheduled-bookkeeping-report"
+
+func (d *db) Lock(ctx context.Context) (func(), bool, error) {
+	return mysql.TryLock(ctx, d.DB, scheduleLock)
+}
+
+func (d *db) GetScheduledRun(ctx context.Context, period string) (*reportjob.ScheduledRun, error) {
+	run := new(reportjob.ScheduledRun)
+	row := d.QueryRowContext(ctx, `select id, period, report_key, notified, created_at from scheduled_report where period = ?;`, period)
+	err := row.Scan(&run.ID, &run.Period, &run.ReportKey, &run.Notified, &run.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (d *db) SaveScheduledRun(ctx context.Context, run *reportjob.ScheduledRun) error {
+	r, err := d.ExecContext(ctx, `insert into scheduled_report (period, report_key, notified) values (?, ?, ?);`, run.Period, run.ReportKey, run.Notified)
+	if err != nil {
+		var mysqlErr *mysqlDriver.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+			return apperror.ErrConflict
+		}
+		d.logger.Errorf("Error %s in saving scheduled report %s for %s", err, run.ReportKey, run.Period)
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	run.ID = uint32(id)
+	return nil
+}
+
+func (d *db) GetDelivered(ctx context.Context, id uint32) ([]string, error) {
+	rows, err := d.QueryContext(ctx, `select notifier from scheduled_report_delivery where scheduled_report_id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]string, 0)
+	for rows.Next() {
+		var notifier string
+		if err := rows.Scan(&notifier); err != nil {
+			return nil, err
+		}
+		res = append(res, notifier)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) SaveDelivered(ctx context.Context, id uint32, notifier string) error {
+	_, err := d.ExecContext(ctx, `insert ignore into scheduled_report_delivery (scheduled_report_id, notifier) values (?, ?);`, id, notifier)
+	return err
+}
+
+func (d *db) MarkNotified(ctx context.Context, id uint32) error {
+	_, err := d.ExecContext(ctx, `update scheduled_report set notified = true where id = ?;`, id)
+	return err
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) reportjob.Storage {
+	return &db{database, logger}
+}
+
+func NewScheduleStorage(database *sql.DB, logger *logging.Logger) reportjob.ScheduleStorage {
+	return &db{database, logger}
+}
@@ -0,0 +1,97 @@
+package reportjob
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	links   *cashaccount.LinkSigner
+	logger  *logging.Logger
+}
+
+// JobResponse has the download link of the report when the job is done
+type JobResponse struct {
+	*Job
+	Link          string     `json:"link,omitempty"`
+	LinkExpiresAt *time.Time `json:"link_expires_at,omitempty"`
+}
+
+func NewHandler(service *Service, links *cashaccount.LinkSigner, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		links:   links,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/report/create/", middleware.Middleware(h.CreateJob))
+	router.Dispatch(http.MethodGet, "/api/report/jobs/:id", middleware.Middleware(h.GetJob))
+}
+
+// response has the download link of the finished job only for the clients with the token of report links
+func (h *handler) response(r *http.Request, job *Job) *JobResponse {
+	resp := &JobResponse{Job: job}
+	if job.Status == StatusDone && h.links.Authorized(r) {
+		link, expiresAt := h.links.Link(job.ReportKey)
+		resp.Link = link
+		resp.LinkExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+func (h *handler) CreateJob(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	req := &cashaccount.BookkeepingReportRequest{
+		Period:      query.Get("period"),
+		From:        query.Get("from"),
+		To:          query.Get("to"),
+		Granularity: cashaccount.Granularity(query.Get("granularity")),
+		Format:      cashaccount.ReportFormat(query.Get("format")),
+		Creator:     query.Get("creator"),
+	}
+	// startTime is the month of the report in the first version of the API
+	if startTime := query.Get("startTime"); startTime != "" {
+		if req.Period != "" {
+			return apperror.ErrBadRequest
+		}
+		req.Period = startTime
+	}
+
+	job, err := h.service.Enqueue(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(h.response(r, job))
+	return nil
+}
+
+func (h *handler) GetJob(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id <= 0 {
+		return apperror.ErrBadRequest
+	}
+
+	job, err := h.service.Get(context.Background(), uint32(id))
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(h.response(r, job))
+	return nil
+}
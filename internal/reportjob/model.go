@@ -0,0 +1,43 @@
+package reportjob
+
+import (
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+)
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Job generates a bookkeeping report in the background
+type Job struct {
+	ID uint32 `json:"id"`
+	cashaccount.BookkeepingReportRequest
+	Status    Status    `json:"status"`
+	ReportKey string    `json:"report_key,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ScheduledRun is the monthly report generated by the scheduler
+type ScheduledRun struct {
+	ID        uint32    `json:"id"`
+	Period    string    `json:"period"`
+	ReportKey string    `json:"report_key"`
+	Notified  bool      `json:"notified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification tells that the scheduled report is ready
+type Notification struct {
+	Period        string    `json:"period"`
+	ReportKey     string    `json:"report_key"`
+	Link          string    `json:"link"`
+	LinkExpiresAt time.Time `json:"link_expires_at"`
+}
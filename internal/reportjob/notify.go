@@ -0,0 +1,107 @@
+package reportjob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"user-balance-service/internal/webhook"
+)
+
+// Notifier delivers the notification about a scheduled report
+type Notifier interface {
+	// Name is saved with the delivered notifications, so it must not change between restarts
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// ReportEvent is sent in the X-Webhook-Event header
+const ReportEvent = "bookkeeping_report.ready"
+
+// WebhookNotifier posts the notification as JSON, the body is signed like
+// the webhooks of the balance events when the secret is set
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url, secret string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{url: url, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+func (w *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, ReportEvent)
+	if w.secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(w.secret, timestamp, payload))
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Report webhook %s responded with status %d", w.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailNotifier sends the notification through an SMTP server
+type EmailNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewEmailNotifier uses PLAIN authentication when the username is set
+func NewEmailNotifier(addr, username, password, from string, to []string) *EmailNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &EmailNotifier{addr: addr, auth: auth, from: from, to: to}
+}
+
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+func (e *EmailNotifier) Notify(ctx context.Context, n *Notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: Bookkeeping report for %s\r\n", n.Period)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "The bookkeeping report for %s is ready.\r\n\r\n", n.Period)
+	fmt.Fprintf(&msg, "Download: %s\r\n", n.Link)
+	fmt.Fprintf(&msg, "The link expires at %s.\r\n", n.LinkExpiresAt.Format(time.RFC1123))
+	return smtp.SendMail(e.addr, e.auth, e.from, e.to, msg.Bytes())
+}
@@ -0,0 +1,187 @@
+package reportjob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+// SchedulerCreator is the creator of the scheduled reports
+const SchedulerCreator = "scheduler"
+
+// ScheduleOptions sets when the report of the previous month is generated
+type ScheduleOptions struct {
+	// Day of the month from 1 to 28, so that every month has it
+	Day      int
+	Hour     int
+	Minute   int
+	Location *time.Location
+	// Granularity and Format of the scheduled report
+	Granularity cashaccount.Granularity
+	Format      cashaccount.ReportFormat
+}
+
+// ParseScheduleTime parses the time of the day like 06:30
+func ParseScheduleTime(value string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid schedule time %s", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// due returns the month of the report which had to be generated before now
+func (o *ScheduleOptions) due(now time.Time) (string, bool) {
+	now = now.In(o.Location)
+	at := time.Date(now.Year(), now.Month(), o.Day, o.Hour, o.Minute, 0, 0, o.Location)
+	if now.Before(at) {
+		return "", false
+	}
+	return at.AddDate(0, -1, 0).Format("2006-01"), true
+}
+
+// Scheduler generates the bookkeeping report of the previous month once a month.
+// A run is saved for every month, so the report is generated once across the replicas
+// and a replica started after the scheduled time catches up
+type Scheduler struct {
+	storage   ScheduleStorage
+	generator Generator
+	links     *cashaccount.LinkSigner
+	notifiers []Notifier
+	options   ScheduleOptions
+	logger    *logging.Logger
+}
+
+// Tick generates the report if it is due at now and delivers the notifications which failed before
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) error {
+	period, ok := s.options.due(now)
+	if !ok {
+		return nil
+	}
+	run, err := s.storage.GetScheduledRun(ctx, period)
+	if err != nil {
+		return err
+	}
+	if run != nil && run.Notified {
+		return nil
+	}
+
+	unlock, ok, err := s.storage.Lock(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	defer unlock()
+
+	// another replica might have generated the report before the lock was taken
+	run, err = s.storage.GetScheduledRun(ctx, period)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		report, err := s.generator.CreateBookkeepingReport(ctx, &cashaccount.BookkeepingReportRequest{
+			Period:      period,
+			Granularity: s.options.Granularity,
+			Format:      s.options.Format,
+			Creator:     SchedulerCreator,
+		})
+		if err != nil {
+			return fmt.Errorf("scheduled report for %s: %w", period, err)
+		}
+		run = &ScheduledRun{Period: period, ReportKey: report.Key, Notified: len(s.notifiers) == 0}
+		if err := s.storage.SaveScheduledRun(ctx, run); err != nil {
+			return err
+		}
+		s.logger.Infof("Generated scheduled bookkeeping report %s for %s", run.ReportKey, period)
+	}
+	if run.Notified {
+		return nil
+	}
+
+	if err := s.notify(ctx, run); err != nil {
+		return err
+	}
+	return s.storage.MarkNotified(ctx, run.ID)
+}
+
+// notify delivers the notification to the notifiers which did not deliver it before
+// and returns the failures of all of them
+func (s *Scheduler) notify(ctx context.Context, run *ScheduledRun) error {
+	delivered, err := s.storage.GetDelivered(ctx, run.ID)
+	if err != nil {
+		return err
+	}
+	link, expiresAt := s.links.Link(run.ReportKey)
+	n := &Notification{Period: run.Period, ReportKey: run.ReportKey, Link: link, LinkExpiresAt: expiresAt}
+
+	failures := make([]string, 0)
+	for _, notifier := range s.notifiers {
+		if contains(delivered, notifier.Name()) {
+			continue
+		}
+		if err := notifier.Notify(ctx, n); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if err := s.storage.SaveDelivered(ctx, run.ID, notifier.Name()); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("notification about the report %s failed: %s", run.ReportKey, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Run checks the schedule every interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(ctx, time.Now()); err != nil {
+			s.logger.Errorf("Error %s in scheduled bookkeeping report", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func NewScheduler(st ScheduleStorage, generator Generator, links *cashaccount.LinkSigner, notifiers []Notifier, options ScheduleOptions, logger *logging.Logger) (*Scheduler, error) {
+	if options.Day < 1 || options.Day > 28 {
+		return nil, fmt.Errorf("Day of the scheduled report must be from 1 to 28, got %d", options.Day)
+	}
+	if options.Location == nil {
+		options.Location = time.UTC
+	}
+	if !options.Granularity.Valid() {
+		return nil, fmt.Errorf("Unknown granularity %s", options.Granularity)
+	}
+	if options.Format == "" {
+		options.Format = cashaccount.ReportCSV
+	}
+	if !options.Format.Valid() {
+		return nil, fmt.Errorf("Unknown report format %s", options.Format)
+	}
+	return &Scheduler{
+		storage:   st,
+		generator: generator,
+		links:     links,
+		notifiers: notifiers,
+		options:   options,
+		logger:    logger,
+	}, nil
+}
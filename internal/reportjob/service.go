@@ -0,0 +1,175 @@
+package reportjob
+
+import (
+	"context"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+const (
+	// maxErrorLength fits job.error column
+	maxErrorLength = 1024
+	// maxJobAttempts limits the workers which may stop on the same job, e.g. when the report does not fit in memory
+	maxJobAttempts = 3
+)
+
+// Generator builds the report, it is implemented by the cash account service
+type Generator interface {
+	CreateBookkeepingReport(ctx context.Context, req *cashaccount.BookkeepingReportRequest) (*cashaccount.BookkeepingReport, error)
+}
+
+type Service struct {
+	storage   Storage
+	generator Generator
+	workers   int
+	lease     time.Duration
+	queue     chan uint32
+	logger    *logging.Logger
+}
+
+// Enqueue creates the job or returns the unfinished job with the same parameters
+func (s *Service) Enqueue(ctx context.Context, data *cashaccount.BookkeepingReportRequest) (*Job, error) {
+	period, err := cashaccount.ParseReportPeriod(data)
+	if err != nil {
+		return nil, err
+	}
+	if data.Creator == "" {
+		data.Creator = "api"
+	}
+
+	job, created, err := s.storage.Create(ctx, &Job{BookkeepingReportRequest: *data, Status: StatusQueued}, period.Key())
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		select {
+		case s.queue <- job.ID:
+		default:
+			// the queue is full, the job is picked up by the next poll
+		}
+	}
+	return job, nil
+}
+
+func (s *Service) Get(ctx context.Context, id uint32) (*Job, error) {
+	return s.storage.Get(ctx, id)
+}
+
+func (s *Service) process(ctx context.Context, id uint32) {
+	ok, err := s.storage.Claim(ctx, id)
+	if err != nil {
+		s.logger.Errorf("Error %s in claiming report job %d", err, id)
+		return
+	}
+	if !ok {
+		return
+	}
+	stop := s.heartbeat(ctx, id)
+	defer stop()
+
+	job, err := s.storage.Get(ctx, id)
+	if err != nil {
+		s.logger.Errorf("Error %s in reading report job %d", err, id)
+		return
+	}
+
+	report, err := s.generator.CreateBookkeepingReport(ctx, &job.BookkeepingReportRequest)
+	if err != nil {
+		reason := err.Error()
+		if len(reason) > maxErrorLength {
+			reason = reason[:maxErrorLength]
+		}
+		s.logger.Errorf("Report job %d failed: %s", id, reason)
+		if err := s.storage.Fail(ctx, id, reason); err != nil {
+			s.logger.Errorf("Error %s in failing report job %d", err, id)
+		}
+		return
+	}
+
+	if err := s.storage.Finish(ctx, id, report.Key); err != nil {
+		s.logger.Errorf("Error %s in finishing report job %d", err, id)
+		return
+	}
+	s.logger.Infof("Report job %d is done, report %s", id, report.Key)
+}
+
+// heartbeat renews the lease of the job until stop is called
+func (s *Service) heartbeat(ctx context.Context, id uint32) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(s.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.storage.Heartbeat(ctx, id); err != nil {
+					s.logger.Errorf("Error %s in renewing the lease of report job %d", err, id)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// Run starts the workers and polls for queued jobs, which were created by other replicas
+// or did not fit into the queue, and for jobs of stopped workers until ctx is done
+func (s *Service) Run(ctx context.Context, pollInterval time.Duration) {
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-s.queue:
+					s.process(ctx, id)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.storage.Expire(ctx, s.lease, maxJobAttempts); err != nil {
+				s.logger.Errorf("Error %s in requeueing expired report jobs", err)
+			}
+			ids, err := s.storage.GetQueued(ctx, uint32(cap(s.queue)))
+			if err != nil {
+				s.logger.Errorf("Error %s in polling report jobs", err)
+				continue
+			}
+			for _, id := range ids {
+				select {
+				case s.queue <- id:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// NewService creates the service, a job is taken by another worker when its worker
+// did not renew the lease for the lease duration
+func NewService(st Storage, generator Generator, workers int, lease time.Duration, logger *logging.Logger) *Service {
+	if workers < 1 {
+		workers = 1
+	}
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	return &Service{
+		storage:   st,
+		generator: generator,
+		workers:   workers,
+		lease:     lease,
+		queue:     make(chan uint32, 100),
+		logger:    logger,
+	}
+}
@@ -0,0 +1,37 @@
+package reportjob
+
+import (
+	"context"
+	"time"
+)
+
+type Storage interface {
+	// Create saves a queued job. If a queued or running job with the same params key exists,
+	// it is returned instead and created is false
+	Create(ctx context.Context, job *Job, paramsKey string) (res *Job, created bool, err error)
+	Get(ctx context.Context, id uint32) (*Job, error)
+	// GetQueued returns the ids of the oldest queued jobs
+	GetQueued(ctx context.Context, limit uint32) ([]uint32, error)
+	// Claim moves the queued job to running and starts its lease, ok is false if another worker took it first
+	Claim(ctx context.Context, id uint32) (ok bool, err error)
+	// Heartbeat renews the lease of the running job
+	Heartbeat(ctx context.Context, id uint32) error
+	// Expire requeues the running jobs whose lease is over because their worker stopped,
+	// the jobs which were claimed maxAttempts times are failed instead
+	Expire(ctx context.Context, lease time.Duration, maxAttempts uint32) error
+	Finish(ctx context.Context, id uint32, reportKey string) error
+	Fail(ctx context.Context, id uint32, reason string) error
+}
+
+// ScheduleStorage keeps the runs of the monthly report scheduler
+type ScheduleStorage interface {
+	// Lock is shared by all the replicas, ok is false if another replica holds it
+	Lock(ctx context.Context) (unlock func(), ok bool, err error)
+	// GetScheduledRun returns nil if the report of the period was not generated yet
+	GetScheduledRun(ctx context.Context, period string) (*ScheduledRun, error)
+	SaveScheduledRun(ctx context.Context, run *ScheduledRun) error
+	// GetDelivered returns the names of the notifiers which delivered the notification about the run
+	GetDelivered(ctx context.Context, id uint32) ([]string, error)
+	SaveDelivered(ctx context.Context, id uint32, notifier string) error
+	MarkNotified(ctx context.Context, id uint32) error
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/statement"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+const statementsLock = "monthly-statements"
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func (d *db) Lock(ctx context.Context) (func(), bool, error) {
+	return mysql.TryLock(ctx, d.DB, statementsLock)
+}
+
+func (d *db) GetPendingUsers(ctx context.Context, period string, from, to time.Time) ([]uint32, error) {
+	rows, err := d.QueryContext(ctx, `select distinct r.service_user_id from user_report r
+		where r.created_at >= ? and r.created_at < ?
+		and not exists (select 1 from user_statement s where s.service_user_id = r.service_user_id and s.period = ?)
+		order by r.service_user_id;`, from, to, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]uint32, 0)
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) Save(ctx context.Context, s *statement.Statement) error {
+	r, err := d.ExecContext(ctx, `insert into user_statement (service_user_id, period, format, file_key) values (?, ?, ?, ?);`, s.UserId, s.Period, s.Format, s.FileKey)
+	if err != nil {
+		d.logger.Errorf("Error %s in saving statement of user %d for %s", err, s.UserId, s.Period)
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.ID = uint32(id)
+	return nil
+}
+
+func (d *db) List(ctx context.Context, userId uint32) ([]*statement.Statement, error) {
+	rows, err := d.QueryContext(ctx, `select id, service_user_id, period, format, file_key, created_at from user_statement where service_user_id = ? order by period desc;`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*statement.Statement, 0)
+	for rows.Next() {
+		s := new(statement.Statement)
+		if err := rows.Scan(&s.ID, &s.UserId, &s.Period, &s.Format, &s.FileKey, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) Get(ctx context.Context, userId uint32, period string) (*statement.Statement, error) {
+	s := new(statement.Statement)
+	row := d.QueryRowContext(ctx, `select id, service_user_id, period, format, file_key, created_at from user_statement where service_user_id = ? and period = ?;`, userId, period)
+	err := row.Scan(&s.ID, &s.UserId, &s.Period, &s.Format, &s.FileKey, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) statement.Storage {
+	return &db{database, logger}
+}
@@ -0,0 +1,78 @@
+package statement
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	logger  *logging.Logger
+}
+
+func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.Dispatch(http.MethodGet, "/api/users/:id/statements", middleware.Middleware(h.ListStatements))
+	router.Dispatch(http.MethodGet, "/api/users/:id/statements/:period", middleware.Middleware(h.GetStatement))
+}
+
+func userId(r *http.Request) (uint32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id <= 0 {
+		return 0, apperror.ErrBadRequest
+	}
+	return uint32(id), nil
+}
+
+func (h *handler) ListStatements(w http.ResponseWriter, r *http.Request) error {
+	id, err := userId(r)
+	if err != nil {
+		return err
+	}
+
+	statements, err := h.service.List(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(statements)
+	return nil
+}
+
+func (h *handler) GetStatement(w http.ResponseWriter, r *http.Request) error {
+	id, err := userId(r)
+	if err != nil {
+		return err
+	}
+	period := httprouter.ParamsFromContext(r.Context()).ByName("period")
+
+	st, content, err := h.service.Open(r.Context(), id, period)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", st.Format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d-%s.%s"`, st.UserId, st.Period, st.Format))
+	if _, err := io.Copy(w, content); err != nil {
+		h.logger.Errorf("Error %s in sending statement of user %d for %s", err, st.UserId, st.Period)
+	}
+	return nil
+}
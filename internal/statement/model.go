@@ -0,0 +1,19 @@
+package statement
+
+import (
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+)
+
+// PeriodFormat is the format of the month of a statement
+const PeriodFormat = "2006-01"
+
+// Statement is a monthly statement of the user saved to the file store
+type Statement struct {
+	ID        uint32                   `json:"id"`
+	UserId    uint32                   `json:"user_id"`
+	Period    string                   `json:"period"`
+	Format    cashaccount.ExportFormat `json:"format"`
+	FileKey   string                   `json:"-"`
+	CreatedAt time.Time                `json:"created_at"`
+}
@@ -0,0 +1,125 @@
+package statement
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/filestore"
+	"user-balance-service/pkg/logging"
+)
+
+// Exporter writes the statement of the user, it is implemented by the cash account service
+type Exporter interface {
+	ExportUserReport(ctx context.Context, uid uint32, format cashaccount.ExportFormat, filter *cashaccount.UserReportFilter, locale cashaccount.Locale, w io.Writer) error
+}
+
+type Service struct {
+	storage  Storage
+	exporter Exporter
+	store    filestore.Store
+	format   cashaccount.ExportFormat
+	locale   cashaccount.Locale
+	logger   *logging.Logger
+}
+
+// Generate creates the statements for the month of the users who do not have them yet
+func (s *Service) Generate(ctx context.Context, month time.Time) (int, error) {
+	unlock, ok, err := s.storage.Lock(ctx)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer unlock()
+
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	period := from.Format(PeriodFormat)
+
+	users, err := s.storage.GetPendingUsers(ctx, period, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	generated := 0
+	for _, userId := range users {
+		if err := s.generate(ctx, userId, period, from, to); err != nil {
+			return generated, fmt.Errorf("statement of user %d for %s: %w", userId, period, err)
+		}
+		generated++
+	}
+	if generated > 0 {
+		s.logger.Infof("Generated %d statements for %s", generated, period)
+	}
+	return generated, nil
+}
+
+func (s *Service) generate(ctx context.Context, userId uint32, period string, from, to time.Time) error {
+	st := &Statement{
+		UserId:  userId,
+		Period:  period,
+		Format:  s.format,
+		FileKey: fmt.Sprintf("statements/%d/%s.%s", userId, period, s.format),
+	}
+
+	// the statement is streamed to the store without keeping it in memory
+	pr, pw := io.Pipe()
+	go func() {
+		filter := &cashaccount.UserReportFilter{From: from, To: to}
+		pw.CloseWithError(s.exporter.ExportUserReport(ctx, userId, s.format, filter, s.locale, pw))
+	}()
+	err := s.store.Put(ctx, st.FileKey, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Save(ctx, st)
+}
+
+// Run generates the statements of the previous month. The check is repeated every interval,
+// so the statements appear soon after the month starts and the missed ones are caught up
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		month := time.Now().UTC().AddDate(0, 0, -time.Now().UTC().Day())
+		if _, err := s.Generate(ctx, month); err != nil {
+			s.logger.Errorf("Error %s in generating monthly statements", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) List(ctx context.Context, userId uint32) ([]*Statement, error) {
+	return s.storage.List(ctx, userId)
+}
+
+// Open returns the statement with its content, the caller has to close it
+func (s *Service) Open(ctx context.Context, userId uint32, period string) (*Statement, io.ReadCloser, error) {
+	if _, err := time.Parse(PeriodFormat, period); err != nil {
+		return nil, nil, apperror.ErrBadRequest
+	}
+	st, err := s.storage.Get(ctx, userId, period)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.store.Get(ctx, st.FileKey)
+	if err == filestore.ErrNotExist {
+		return nil, nil, apperror.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return st, content, nil
+}
+
+func NewService(st Storage, exporter Exporter, store filestore.Store, format cashaccount.ExportFormat, locale cashaccount.Locale, logger *logging.Logger) *Service {
+	return &Service{st, exporter, store, format, locale, logger}
+}
@@ -0,0 +1,16 @@
+package statement
+
+import (
+	"context"
+	"time"
+)
+
+type Storage interface {
+	// Lock makes sure only one replica generates statements at a time
+	Lock(ctx context.Context) (unlock func(), ok bool, err error)
+	// GetPendingUsers returns the users who had operations in [from, to) and have no statement for the period yet
+	GetPendingUsers(ctx context.Context, period string, from, to time.Time) ([]uint32, error)
+	Save(ctx context.Context, s *Statement) error
+	List(ctx context.Context, userId uint32) ([]*Statement, error)
+	Get(ctx context.Context, userId uint32, period string) (*Statement, error)
+}
@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+	"user-balance-service/internal/analytics"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/logging"
+)
+
+type row struct {
+	userId    uint32
+	serviceId uint32
+	amount    float64
+	createdAt time.Time
+}
+
+// storage aggregates the rows in memory, only the totals and the filters are needed by the service
+type storage struct {
+	rows    []row
+	filters []*analytics.Filter
+}
+
+func (s *storage) Revenue(ctx context.Context, filter *analytics.Filter) ([]*analytics.RevenuePoint, error) {
+	s.filters = append(s.filters, filter)
+	return nil, nil
+}
+
+func (s *storage) TopServices(ctx context.Context, filter *analytics.Filter) ([]*analytics.ServiceRevenue, error) {
+	s.filters = append(s.filters, filter)
+	return nil, nil
+}
+
+func (s *storage) PayingUsers(ctx context.Context, filter *analytics.Filter) ([]*analytics.PayingUsersPoint, error) {
+	s.filters = append(s.filters, filter)
+	return nil, nil
+}
+
+func (s *storage) AverageCheck(ctx context.Context, filter *analytics.Filter) ([]*analytics.CheckPoint, error) {
+	s.filters = append(s.filters, filter)
+	return nil, nil
+}
+
+func (s *storage) Totals(ctx context.Context, filter *analytics.Filter) (*analytics.Totals, error) {
+	res := new(analytics.Totals)
+	users := make(map[uint32]bool)
+	for _, r := range s.rows {
+		if r.createdAt.Before(filter.Start) || !r.createdAt.Before(filter.End) {
+			continue
+		}
+		if filter.ServiceId != 0 && r.serviceId != filter.ServiceId {
+			continue
+		}
+		res.Revenue += r.amount
+		res.Orders++
+		users[r.userId] = true
+	}
+	res.PayingUsers = uint32(len(users))
+	if res.Orders > 0 {
+		res.AverageCheck = res.Revenue / float64(res.Orders)
+	}
+	return res, nil
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestQueries(t *testing.T) {
+	st := &storage{}
+	s := analytics.NewService(st, logging.NewLogger())
+
+	_, err := s.Revenue(context.Background(), &analytics.Query{From: "2022-01-10", To: "2022-02-09", Granularity: cashaccount.GranularityWeek, ServiceId: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := st.filters[0]
+	if !f.Start.Equal(time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC)) || !f.End.Equal(time.Date(2022, 2, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected range %s - %s", f.Start, f.End)
+	}
+	if f.Granularity != cashaccount.GranularityWeek || f.ServiceId != 3 {
+		t.Errorf("Unexpected filter %+v", f)
+	}
+
+	if _, err := s.TopServices(context.Background(), &analytics.Query{Period: "2022-Q1"}); err != nil {
+		t.Fatal(err)
+	}
+	if st.filters[1].Limit != 10 {
+		t.Errorf("Default limit must be 10, got %d", st.filters[1].Limit)
+	}
+
+	invalid := []*analytics.Query{
+		{},
+		{From: "2022-02-01", To: "2022-01-01"},
+		{Period: "2022-03", Granularity: "hour"},
+		{Period: "2022-03", Limit: 1000},
+	}
+	for _, q := range invalid {
+		if _, err := s.TopServices(context.Background(), q); !errors.Is(err, apperror.ErrBadRequest) {
+			t.Errorf("Query %+v must be rejected, got %v", q, err)
+		}
+	}
+}
+
+func TestCompareMonths(t *testing.T) {
+	st := &storage{rows: []row{
+		{1, 1, 100, time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{2, 1, 100, time.Date(2022, 2, 28, 23, 0, 0, 0, time.UTC)},
+		{1, 1, 150, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{1, 2, 50, time.Date(2022, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{3, 1, 150, time.Date(2022, 3, 31, 23, 59, 59, 0, time.UTC)},
+		{3, 1, 999, time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}}
+	s := analytics.NewService(st, logging.NewLogger())
+
+	res, err := s.CompareMonths(context.Background(), "2022-03", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PreviousMonth != "2022-02" || res.Current.Revenue != 350 || res.Previous.Revenue != 200 {
+		t.Fatalf("Unexpected comparison %+v %+v %+v", res, res.Current, res.Previous)
+	}
+	if res.RevenueChange != 150 || res.RevenueChangePercent == nil || *res.RevenueChangePercent != 75 {
+		t.Errorf("Unexpected revenue change %f", res.RevenueChange)
+	}
+	if res.PayingUsersChangePercent == nil || *res.PayingUsersChangePercent != 0 {
+		t.Error("Paying users did not change")
+	}
+
+	res, err = s.CompareMonths(context.Background(), "2022-03", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Current.Revenue != 50 || res.RevenueChangePercent != nil {
+		t.Error("Service without revenue in the previous month has no percent change")
+	}
+
+	if _, err := s.CompareMonths(context.Background(), "March", 0); !errors.Is(err, apperror.ErrBadRequest) {
+		t.Errorf("Invalid month must be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/internal/promo"
+	"user-balance-service/pkg/logging"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestAdminToken(t *testing.T) {
+	if _, err := middleware.NewToken(""); err == nil {
+		t.Error("Empty token must be rejected")
+	}
+	token, err := middleware.NewToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for header, authorized := range map[string]bool{"": false, "admin": false, "Bearer": false, "Bearer other": false, "Basic admin": false, "Bearer admin": true} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", header)
+		if token.Authorized(req) != authorized {
+			t.Errorf("Request with %q must be authorized: %t", header, authorized)
+		}
+	}
+}
+
+func TestPromoCodesNeedAdminToken(t *testing.T) {
+	token, _ := middleware.NewToken("admin")
+	router := handlers.NewRouter()
+	promo.NewHandler(nil, token, logging.NewLogger()).Register(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/promo/", strings.NewReader(`{"code": "FREE", "amount": 1000}`)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Promo code must not be created without the token, got %d", w.Code)
+	}
+}
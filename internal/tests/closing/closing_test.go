@@ -0,0 +1,284 @@
+package closing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/closing"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps closed periods in memory, adjustments fail while the current day is closed like the bookkeeping triggers
+type storage struct {
+	mu          sync.Mutex
+	periods     []*closing.ClosedPeriod
+	adjustments []*closing.Adjustment
+}
+
+func (s *storage) Close(ctx context.Context, period *closing.ClosedPeriod) (*closing.ClosedPeriod, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.periods {
+		if p.Period == period.Period {
+			copied := *p
+			return &copied, false, nil
+		}
+	}
+	period.ID = uint32(len(s.periods) + 1)
+	period.ClosedAt = time.Now()
+	s.periods = append(s.periods, period)
+	copied := *period
+	return &copied, true, nil
+}
+
+func (s *storage) SetReport(ctx context.Context, id uint32, key, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.periods[id-1].ReportKey = key
+	s.periods[id-1].ReportHash = hash
+	return nil
+}
+
+func (s *storage) Get(ctx context.Context, period string) (*closing.ClosedPeriod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.periods {
+		if p.Period == period {
+			copied := *p
+			return &copied, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (s *storage) List(ctx context.Context) ([]*closing.ClosedPeriod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*closing.ClosedPeriod, 0, len(s.periods))
+	for i := len(s.periods) - 1; i >= 0; i-- {
+		copied := *s.periods[i]
+		res = append(res, &copied)
+	}
+	return res, nil
+}
+
+func (s *storage) SaveAdjustment(ctx context.Context, adjustment *closing.Adjustment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, p := range s.periods {
+		if !now.Before(p.Start) && now.Before(p.End) {
+			return apperror.ErrConflict
+		}
+	}
+	adjustment.ID = uint32(len(s.adjustments) + 1)
+	adjustment.CreatedAt = now
+	copied := *adjustment
+	s.adjustments = append(s.adjustments, &copied)
+	return nil
+}
+
+func (s *storage) GetAdjustments(ctx context.Context, period string) ([]*closing.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*closing.Adjustment, 0)
+	for _, a := range s.adjustments {
+		if a.Period == period {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+// generator fails while failing is set, the hash depends on the period only like a report of frozen rows
+type generator struct {
+	calls   int
+	failing bool
+}
+
+func (g *generator) CreateBookkeepingReport(ctx context.Context, req *cashaccount.BookkeepingReportRequest) (*cashaccount.BookkeepingReport, error) {
+	g.calls++
+	if g.failing {
+		return nil, errors.New("database is not available")
+	}
+	return &cashaccount.BookkeepingReport{Key: req.Period + "-key", Hash: req.Period + "-hash", CreatedBy: req.Creator}, nil
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestClose(t *testing.T) {
+	st := &storage{}
+	gen := &generator{}
+	s := closing.NewService(st, gen, logging.NewLogger())
+
+	for _, period := range []string{"", "2020-13", "2020-Q1", time.Now().Format("2006-01"), time.Now().AddDate(0, 1, 0).Format("2006-01")} {
+		if _, err := s.Close(context.Background(), &closing.CloseRequest{Period: period}); !errors.Is(err, apperror.ErrBadRequest) {
+			t.Errorf("Period %q must not be closed, got %v", period, err)
+		}
+	}
+
+	gen.failing = true
+	if _, err := s.Close(context.Background(), &closing.CloseRequest{Period: "2020-01", ClosedBy: "finance"}); err == nil {
+		t.Fatal("Error of the report must be returned")
+	}
+	period, err := s.Get(context.Background(), "2020-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if period.ReportKey != "" {
+		t.Error("Failed report must not be saved")
+	}
+
+	gen.failing = false
+	period, err = s.Close(context.Background(), &closing.CloseRequest{Period: "2020-01", ClosedBy: "finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if period.ReportKey != "2020-01-key" || period.ReportHash != "2020-01-hash" || period.ClosedBy != "finance" {
+		t.Errorf("Unexpected closed period %+v", period)
+	}
+	if !period.End.Equal(period.Start.AddDate(0, 1, 0)) {
+		t.Errorf("Period must be a month, got %s - %s", period.Start, period.End)
+	}
+	if !period.Start.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Period must start at the beginning of the month in UTC, got %s", period.Start)
+	}
+
+	if _, err := s.Close(context.Background(), &closing.CloseRequest{Period: "2020-01"}); !errors.Is(err, apperror.ErrConflict) {
+		t.Errorf("Period must be closed once, got %v", err)
+	}
+	if gen.calls != 2 {
+		t.Errorf("Report must be generated until it succeeds, got %d calls", gen.calls)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	st := &storage{}
+	s := closing.NewService(st, &generator{}, logging.NewLogger())
+	if _, err := s.Close(context.Background(), &closing.CloseRequest{Period: "2020-02"}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []*closing.Adjustment{
+		{Period: "2020-03", ServiceId: 1, Amount: 10, Reason: "open period"},
+		{Period: "2020-02", ServiceId: 0, Amount: 10, Reason: "no service"},
+		{Period: "2020-02", ServiceId: 1, Amount: 0, Reason: "no amount"},
+		{Period: "2020-02", ServiceId: 1, Amount: 10, Reason: "  "},
+		{Period: "2020-02", ServiceId: 1, Amount: 10, Reason: strings.Repeat("a", 256)},
+	}
+	for _, a := range invalid {
+		if err := s.Adjust(context.Background(), a); !errors.Is(err, apperror.ErrBadRequest) {
+			t.Errorf("Adjustment %+v must be rejected, got %v", a, err)
+		}
+	}
+
+	refund := &closing.Adjustment{Period: "2020-02", ServiceId: 1, Amount: -15.5, Reason: " late refund "}
+	if err := s.Adjust(context.Background(), refund); err != nil {
+		t.Fatal(err)
+	}
+	if refund.Reason != "late refund" || refund.CreatedBy != "api" || refund.ID == 0 {
+		t.Errorf("Unexpected adjustment %+v", refund)
+	}
+
+	adjustments, err := s.GetAdjustments(context.Background(), "2020-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adjustments) != 1 || adjustments[0].Amount != -15.5 {
+		t.Errorf("Unexpected adjustments %+v", adjustments)
+	}
+	if _, err := s.GetAdjustments(context.Background(), "2020-03"); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("Open period has no adjustments, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	links, err := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := middleware.NewToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := handlers.NewRouter()
+	closing.NewHandler(closing.NewService(&storage{}, &generator{}, logging.NewLogger()), links, admin, logging.NewLogger()).Register(router)
+
+	adminRequest := func(path, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		return req
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/periods/", strings.NewReader(`{"period": "2020-04", "closed_by": "finance"}`)),
+		httptest.NewRequest(http.MethodPost, "/api/periods/2020-04/adjustments", strings.NewReader(`{"service_id": 1, "amount": 5, "reason": "missed order"}`)),
+	} {
+		req.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s without the admin token must be forbidden, got %d", req.URL.Path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("/api/periods/", `{"period": "2020-04", "closed_by": "finance"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("/api/periods/2020-04/adjustments", `{"service_id": 1, "amount": 5, "reason": "missed order"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/periods/2020-04", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+	var resp closing.PeriodResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Link != "" || len(resp.Adjustments) != 1 {
+		t.Errorf("Period without the token must have no link, got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/periods/2020-04", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+	resp = closing.PeriodResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Link, "http://localhost:8080/api/report/2020-04-key?") || len(resp.Adjustments) != 1 {
+		t.Errorf("Unexpected period %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/periods/2020-05", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Open period must not be found, got %d", w.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"user-balance-service/pkg/pdf"
+	"user-balance-service/pkg/xlsx"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestXlsxWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := xlsx.NewWriter(&buf, "Statement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow("Date", "Amount", "Description"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow("2022-10-01", float32(-10.5), "Transferring <money> & more"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range z.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(content)
+		}
+	}
+
+	if !strings.Contains(sheet, `<c r="B2"><v>-10.50</v></c>`) {
+		t.Errorf("Amount must be a numeric cell: %s", sheet)
+	}
+	if !strings.Contains(sheet, `Transferring &lt;money&gt; &amp; more`) {
+		t.Errorf("Text must be escaped: %s", sheet)
+	}
+	if len(z.File) != 5 {
+		t.Errorf("Expected 5 parts, got %d", len(z.File))
+	}
+}
+
+func TestPdfWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pdf.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enough lines for several pages
+	for i := 0; i < 200; i++ {
+		if err := w.Line(fmt.Sprintf("Перевод (%d)", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+
+	if !strings.HasPrefix(doc, "%PDF-1.4") || !strings.HasSuffix(doc, "%%EOF\n") {
+		t.Fatal("Document must start with the header and end with the trailer")
+	}
+	if !strings.Contains(doc, `(Perevod \(199\)) '`) {
+		t.Error("Text must be transliterated and escaped")
+	}
+	if !regexp.MustCompile(`/Count [3-9] `).MatchString(doc) {
+		t.Error("Lines must be split into several pages")
+	}
+
+	// every entry of the cross-reference table must point to its object
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(doc)
+	offset, _ := strconv.Atoi(startxref[1])
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(doc[offset:], -1)
+	for i, e := range entries {
+		pos, _ := strconv.Atoi(e[1])
+		if !strings.HasPrefix(doc[pos:], fmt.Sprintf("%d 0 obj", i+1)) {
+			t.Errorf("Wrong offset of the object %d", i+1)
+		}
+	}
+}
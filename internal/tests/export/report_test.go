@@ -0,0 +1,113 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/pkg/xlsx"
+)
+
+func bookkeepingReport(granularity cashaccount.Granularity) (*cashaccount.BookkeepingReport, []*cashaccount.BookkeepingReportRow) {
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	report := &cashaccount.BookkeepingReport{PeriodStart: start, PeriodEnd: start.AddDate(0, 1, 0), Granularity: granularity}
+	rows := []*cashaccount.BookkeepingReportRow{
+		{Period: start, ServiceId: 1, Amount: 100.5},
+		{Period: start.AddDate(0, 0, 7), ServiceId: 2, Amount: 20},
+	}
+	return report, rows
+}
+
+func TestCSVReport(t *testing.T) {
+	report, rows := bookkeepingReport(cashaccount.GranularityNone)
+	renderer := cashaccount.NewReportRenderer(cashaccount.ReportCSV, cashaccount.DefaultCSVSeparator)
+	var buf bytes.Buffer
+	if err := renderer.Render(&buf, report, rows); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "service_id;amount\n1;100.50\n2;20.00\n" {
+		t.Errorf("Unexpected CSV report %q", buf.String())
+	}
+	if renderer.Extension() != "csv" || !strings.HasPrefix(renderer.ContentType(), "text/csv") {
+		t.Errorf("Unexpected CSV file type %s %s", renderer.Extension(), renderer.ContentType())
+	}
+
+	report, rows = bookkeepingReport(cashaccount.GranularityWeek)
+	buf.Reset()
+	if err := cashaccount.NewReportRenderer(cashaccount.ReportCSV, ',').Render(&buf, report, rows); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "period,service_id,amount\n2022-03-01,1,100.50\n2022-03-08,2,20.00\n" {
+		t.Errorf("Unexpected weekly CSV report %q", buf.String())
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	report, rows := bookkeepingReport(cashaccount.GranularityDay)
+	renderer := cashaccount.NewReportRenderer(cashaccount.ReportJSON, cashaccount.DefaultCSVSeparator)
+	var buf bytes.Buffer
+	if err := renderer.Render(&buf, report, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	var file struct {
+		PeriodStart time.Time `json:"period_start"`
+		Granularity string    `json:"granularity"`
+		Rows        []struct {
+			Period    string  `json:"period"`
+			ServiceId uint32  `json:"service_id"`
+			Amount    float32 `json:"amount"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Granularity != "day" || !file.PeriodStart.Equal(report.PeriodStart) || len(file.Rows) != 2 {
+		t.Fatalf("Unexpected JSON report %s", buf.String())
+	}
+	if file.Rows[1].Period != "2022-03-08" || file.Rows[1].ServiceId != 2 || file.Rows[1].Amount != 20 {
+		t.Errorf("Unexpected JSON row %+v", file.Rows[1])
+	}
+	if renderer.ContentType() != "application/json" {
+		t.Errorf("Unexpected content type %s", renderer.ContentType())
+	}
+}
+
+func TestXLSXReport(t *testing.T) {
+	report, rows := bookkeepingReport(cashaccount.GranularityNone)
+	renderer := cashaccount.NewReportRenderer(cashaccount.ReportXLSX, cashaccount.DefaultCSVSeparator)
+	var buf bytes.Buffer
+	if err := renderer.Render(&buf, report, rows); err != nil {
+		t.Fatal(err)
+	}
+	if renderer.ContentType() != xlsx.ContentType || renderer.Extension() != "xlsx" {
+		t.Errorf("Unexpected XLSX file type %s %s", renderer.Extension(), renderer.ContentType())
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range z.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet, _ := io.ReadAll(r)
+		r.Close()
+		for _, value := range []string{"service_id", "amount", "100.5"} {
+			if !strings.Contains(string(sheet), value) {
+				t.Errorf("Sheet has no %s", value)
+			}
+		}
+		return
+	}
+	t.Error("Report has no sheet")
+}
@@ -0,0 +1,128 @@
+package link
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/pkg/logging"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestReportLinks(t *testing.T) {
+	now := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	signer, err := cashaccount.NewLinkSigner("https://balance.example.com/", "secret", "token", time.Hour, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link, expiresAt := signer.Link("2022-03-5f1c9a0b7d3e2a41")
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Link must expire in an hour, got %s", expiresAt)
+	}
+	if !strings.HasPrefix(link, "https://balance.example.com/api/report/2022-03-5f1c9a0b7d3e2a41?") {
+		t.Errorf("Link must start with the public url, got %s", link)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+	if err := signer.Verify("2022-03-5f1c9a0b7d3e2a41", expires, signature); err != nil {
+		t.Errorf("Valid link is rejected: %s", err)
+	}
+
+	invalid := [][3]string{
+		{"2022-03-0000000000000000", expires, signature},
+		{"2022-03-5f1c9a0b7d3e2a41", "9999999999", signature},
+		{"2022-03-5f1c9a0b7d3e2a41", expires, strings.Repeat("0", len(signature))},
+		{"2022-03-5f1c9a0b7d3e2a41", "", ""},
+	}
+	for _, c := range invalid {
+		if err := signer.Verify(c[0], c[1], c[2]); err == nil {
+			t.Errorf("Tampered link %v must be rejected", c)
+		}
+	}
+
+	other, _ := cashaccount.NewLinkSigner("https://balance.example.com", "another secret", "token", time.Hour, clock)
+	if err := other.Verify("2022-03-5f1c9a0b7d3e2a41", expires, signature); err == nil {
+		t.Error("Link signed with another secret must be rejected")
+	}
+
+	now = now.Add(time.Hour)
+	if err := signer.Verify("2022-03-5f1c9a0b7d3e2a41", expires, signature); err == nil {
+		t.Error("Expired link must be rejected")
+	}
+
+	if _, err := cashaccount.NewLinkSigner("https://balance.example.com", "", "token", time.Hour, nil); err == nil {
+		t.Error("Empty secret must be rejected")
+	}
+	if _, err := cashaccount.NewLinkSigner("https://balance.example.com", "dev-report-link-secret", "token", time.Hour, nil); err == nil {
+		t.Error("Development secret must be rejected")
+	}
+	if _, err := cashaccount.NewLinkSigner("https://balance.example.com", "secret", "", time.Hour, nil); err == nil {
+		t.Error("Empty token must be rejected")
+	}
+}
+
+func TestReportLinksNeedToken(t *testing.T) {
+	signer, err := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := handlers.NewRouter()
+	cashaccount.NewHandler(nil, nil, signer, logging.NewLogger()).Register(router)
+
+	for _, header := range []string{"", "token", "Bearer", "Bearer other", "Basic token"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/report/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if signer.Authorized(req) {
+			t.Errorf("Request with %q must not get links", header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Reports with %q must be forbidden, got %d", header, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/report/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	if !signer.Authorized(req) {
+		t.Error("Request with the token must get links")
+	}
+}
+
+func TestReportDownloadIsSigned(t *testing.T) {
+	signer, err := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := handlers.NewRouter()
+	cashaccount.NewHandler(nil, nil, signer, logging.NewLogger()).Register(router)
+
+	for _, target := range []string{
+		"/api/report/2022-03-5f1c9a0b7d3e2a41",
+		"/api/report/2022-03-5f1c9a0b7d3e2a41?expires=9999999999&signature=00",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Download by %s must be forbidden, got %d", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package operation
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	cashaccount "user-balance-service/internal/cash_account"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestDescribe(t *testing.T) {
+	cases := []struct {
+		op          cashaccount.Operation
+		amount      float32
+		description string
+	}{
+		{cashaccount.Operation{Type: cashaccount.OperationTopUp, Direction: cashaccount.Credit}, 10, "Account replenished"},
+		{cashaccount.Operation{Type: cashaccount.OperationWithdraw, Direction: cashaccount.Debit}, 10, "Debiting money from an account"},
+		{cashaccount.Operation{Type: cashaccount.OperationTransfer, Direction: cashaccount.Debit, CounterpartyId: 2}, 10, "Transferring money to a user 2"},
+		{cashaccount.Operation{Type: cashaccount.OperationTransfer, Direction: cashaccount.Credit, CounterpartyId: 1}, 10, "Receiving money from the user 1"},
+		{cashaccount.Operation{Type: cashaccount.OperationReserve, Direction: cashaccount.Debit, ServiceId: 1, OrderId: 3}, 20, "The money 20.000000 was reserved for the order 3 and the service 1"},
+		{cashaccount.Operation{Type: cashaccount.OperationAccept, Direction: cashaccount.None, ServiceId: 1, OrderId: 3}, 20, "The money 20.000000 was accepted for the order 3 and the service 1"},
+		{cashaccount.Operation{Type: cashaccount.OperationPromo, Direction: cashaccount.Credit, Reference: "WELCOME"}, 5, "Account replenished by the promo code WELCOME"},
+		{cashaccount.Operation{Type: cashaccount.OperationPayoutReturn, Direction: cashaccount.Credit, Reference: "7"}, 5, "The money 5.000000 was returned after the failed payout 7"},
+	}
+
+	for _, c := range cases {
+		if got := c.op.Describe(cashaccount.English, c.amount); got != c.description {
+			t.Errorf("Expected %q for %s, got %q", c.description, c.op.Type, got)
+		}
+	}
+}
+
+func TestDescribeRussian(t *testing.T) {
+	op := cashaccount.Operation{Type: cashaccount.OperationTransfer, Direction: cashaccount.Credit, CounterpartyId: 1}
+	if got := op.Describe(cashaccount.Russian, 10); got != "Перевод от пользователя 1" {
+		t.Errorf("Unexpected description %q", got)
+	}
+
+	op = cashaccount.Operation{Type: cashaccount.OperationReserve, Direction: cashaccount.Debit, ServiceId: 1, OrderId: 3}
+	if got := op.Describe(cashaccount.Russian, 20); got != "Резервирование 20.00 для заказа 3 услуги 1" {
+		t.Errorf("Unexpected description %q", got)
+	}
+}
+
+func TestLocaleFromRequest(t *testing.T) {
+	cases := []struct {
+		url            string
+		acceptLanguage string
+		locale         cashaccount.Locale
+	}{
+		{"/api/users/report/?id=1", "", cashaccount.English},
+		{"/api/users/report/?id=1&lang=ru", "en-US", cashaccount.Russian},
+		{"/api/users/report/?id=1&lang=de", "ru-RU,ru;q=0.9", cashaccount.Russian},
+		{"/api/users/report/?id=1", "de-DE,en;q=0.5,ru;q=0.8", cashaccount.Russian},
+		{"/api/users/report/?id=1", "de-DE", cashaccount.English},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.url, nil)
+		if c.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", c.acceptLanguage)
+		}
+		if got := cashaccount.LocaleFromRequest(r); got != c.locale {
+			t.Errorf("Expected %s for %s with %q, got %s", c.locale, c.url, c.acceptLanguage, got)
+		}
+	}
+}
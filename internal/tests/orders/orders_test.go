@@ -0,0 +1,206 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/orders"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps processed messages in memory
+type storage struct {
+	replies map[string]*orders.Reply
+}
+
+type replyKey struct{}
+
+func (s *storage) GetReply(ctx context.Context, messageId string) (*orders.Reply, error) {
+	return s.replies[messageId], nil
+}
+
+func (s *storage) SaveReply(ctx context.Context, reply *orders.Reply) error {
+	if _, ok := s.replies[reply.MessageId]; !ok {
+		s.replies[reply.MessageId] = reply
+	}
+	return nil
+}
+
+func (s *storage) WithReply(ctx context.Context, reply *orders.Reply) context.Context {
+	return context.WithValue(ctx, replyKey{}, reply)
+}
+
+// commit saves the reply of the context like the transaction of a successful change
+func (s *storage) commit(ctx context.Context) {
+	reply := ctx.Value(replyKey{}).(*orders.Reply)
+	s.replies[reply.MessageId] = reply
+}
+
+// accounts counts calls to the cash account service, reserve fails with a transient error failures times
+type accounts struct {
+	storage                      *storage
+	reserved, accepted, canceled int
+	failures                     int
+	unreserve                    bool
+}
+
+func (a *accounts) Reserve(ctx context.Context, data *cashaccount.ReserveDetails) error {
+	a.reserved++
+	if a.failures > 0 {
+		a.failures--
+		return errors.New("Connection lost")
+	}
+	a.storage.commit(ctx)
+	return nil
+}
+
+func (a *accounts) TryAcceptRevenue(ctx context.Context, data *cashaccount.ReserveDetails) error {
+	a.accepted++
+	a.storage.commit(ctx)
+	if a.unreserve {
+		return cashaccount.ErrUnreserved
+	}
+	return nil
+}
+
+func (a *accounts) CancelReservation(ctx context.Context, data *cashaccount.ReserveDetails) error {
+	a.canceled++
+	return apperror.ErrNotFound
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestProcessDeduplicates(t *testing.T) {
+	st := &storage{replies: make(map[string]*orders.Reply)}
+	acc := &accounts{storage: st}
+	queue := orders.NewMemoryQueue(10)
+	service := orders.NewService(st, acc, queue, queue, logging.NewLogger())
+
+	details := cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 1, Amount: 10}
+	commands := []*orders.Command{
+		{MessageId: "1", Type: orders.CommandReserve, ReserveDetails: details},
+		{MessageId: "1", Type: orders.CommandReserve, ReserveDetails: details},
+		{MessageId: "2", Type: orders.CommandAccept, ReserveDetails: details},
+		{MessageId: "3", Type: orders.CommandCancel, ReserveDetails: details},
+		{MessageId: "4", Type: "unknown", ReserveDetails: details},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx)
+		close(done)
+	}()
+	for _, cmd := range commands {
+		queue.Send(cmd)
+	}
+	for len(queue.Replies()) < len(commands) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if acc.reserved != 1 || acc.accepted != 1 || acc.canceled != 1 {
+		t.Errorf("Unexpected calls: reserve %d, accept %d, cancel %d", acc.reserved, acc.accepted, acc.canceled)
+	}
+
+	replies := queue.Replies()
+	if replies[0].Status != orders.ReplyOk || *replies[1] != *replies[0] {
+		t.Errorf("Redelivered message must get the same reply, got %+v and %+v", replies[0], replies[1])
+	}
+	if replies[3].Status != orders.ReplyError || replies[3].Error == "" {
+		t.Errorf("Failed command must get an error reply, got %+v", replies[3])
+	}
+	if replies[4].Status != orders.ReplyError {
+		t.Errorf("Unknown command must get an error reply, got %+v", replies[4])
+	}
+}
+
+func TestProcessRetriesTransientErrors(t *testing.T) {
+	st := &storage{replies: make(map[string]*orders.Reply)}
+	acc := &accounts{storage: st, failures: 2}
+	queue := orders.NewMemoryQueue(10)
+	service := orders.NewService(st, acc, queue, queue, logging.NewLogger())
+
+	cmd := &orders.Command{MessageId: "1", Type: orders.CommandReserve, ReserveDetails: cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 1, Amount: 10}}
+	for i := 0; i < 2; i++ {
+		if err := service.Process(context.Background(), cmd); err == nil {
+			t.Fatal("Transient error must be returned")
+		}
+	}
+	if len(st.replies) != 0 || len(queue.Replies()) != 0 {
+		t.Fatalf("Transient error must not be replied, got %+v", queue.Replies())
+	}
+
+	if err := service.Process(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	replies := queue.Replies()
+	if acc.reserved != 3 || len(replies) != 1 || replies[0].Status != orders.ReplyOk {
+		t.Errorf("Retried command must succeed once, got %d calls and %+v", acc.reserved, replies)
+	}
+}
+
+func TestProcessRepliesErrorAfterAttempts(t *testing.T) {
+	st := &storage{replies: make(map[string]*orders.Reply)}
+	acc := &accounts{storage: st, failures: 100}
+	queue := orders.NewMemoryQueue(10)
+	service := orders.NewService(st, acc, queue, queue, logging.NewLogger())
+
+	cmd := &orders.Command{MessageId: "1", Type: orders.CommandReserve, ReserveDetails: cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 1, Amount: 10}}
+	for i := 0; i < 4; i++ {
+		if err := service.Process(context.Background(), cmd); err == nil {
+			t.Fatal("Transient error must be returned")
+		}
+	}
+	if err := service.Process(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	replies := queue.Replies()
+	if acc.reserved != 5 || len(replies) != 1 || replies[0].Status != orders.ReplyError {
+		t.Fatalf("Command must be answered with an error after the last attempt, got %d calls and %+v", acc.reserved, replies)
+	}
+	if st.replies["1"] == nil || st.replies["1"].Status != orders.ReplyError {
+		t.Error("Error reply must be saved for the redelivered message")
+	}
+}
+
+func TestProcessRepliesErrorToUnreservedAccept(t *testing.T) {
+	st := &storage{replies: make(map[string]*orders.Reply)}
+	acc := &accounts{storage: st, unreserve: true}
+	queue := orders.NewMemoryQueue(10)
+	service := orders.NewService(st, acc, queue, queue, logging.NewLogger())
+
+	cmd := &orders.Command{MessageId: "1", Type: orders.CommandAccept, ReserveDetails: cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 1, Amount: 10}}
+	if err := service.Process(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	replies := queue.Replies()
+	if len(replies) != 1 || replies[0].Status != orders.ReplyError || replies[0].Error == "" {
+		t.Errorf("Accept which returned the reserved money must get an error reply, got %+v", replies)
+	}
+}
+
+func TestProcessRejectsCommandWithoutMessageId(t *testing.T) {
+	st := &storage{replies: make(map[string]*orders.Reply)}
+	acc := &accounts{storage: st}
+	queue := orders.NewMemoryQueue(10)
+	service := orders.NewService(st, acc, queue, queue, logging.NewLogger())
+
+	cmd := &orders.Command{Type: orders.CommandReserve, ReserveDetails: cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 1, Amount: 10}}
+	if err := service.Process(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	replies := queue.Replies()
+	if acc.reserved != 0 || len(replies) != 1 || replies[0].Status != orders.ReplyError || replies[0].OrderId != 1 {
+		t.Errorf("Command without message id must be rejected, got %d calls and %+v", acc.reserved, replies)
+	}
+}
@@ -0,0 +1,154 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/outbox"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps the outbox in memory
+type storage struct {
+	messages  []*outbox.Message
+	published map[uint64]bool
+}
+
+func (s *storage) Lock(ctx context.Context) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s *storage) GetUnpublished(ctx context.Context, limit uint32) ([]*outbox.Message, error) {
+	res := make([]*outbox.Message, 0)
+	for _, m := range s.messages {
+		if !s.published[m.ID] && uint32(len(res)) < limit {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) MarkPublished(ctx context.Context, ids []uint64) error {
+	for _, id := range ids {
+		s.published[id] = true
+	}
+	return nil
+}
+
+// failingPublisher fails the first publication
+type failingPublisher struct {
+	*outbox.MemoryPublisher
+	failed bool
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, msgs []*outbox.Message) error {
+	if !p.failed {
+		p.failed = true
+		return errors.New("broker is not available")
+	}
+	return p.MemoryPublisher.Publish(ctx, msgs)
+}
+
+func newStorage(n int) *storage {
+	st := &storage{published: make(map[uint64]bool)}
+	for i := 1; i <= n; i++ {
+		payload, _ := json.Marshal(&events.Event{Type: events.Accrual, UserId: uint32(i % 2), Amount: float32(i)})
+		st.messages = append(st.messages, &outbox.Message{ID: uint64(i), UserId: uint32(i % 2), Type: events.Accrual, Payload: payload})
+	}
+	return st
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestRelayRetriesInOrder(t *testing.T) {
+	st := newStorage(5)
+	publisher := &failingPublisher{MemoryPublisher: outbox.NewMemoryPublisher()}
+	relay := outbox.NewRelay(st, publisher, 2, logging.NewLogger())
+
+	if err := relay.Flush(context.Background()); err == nil {
+		t.Error("Publisher error must be returned")
+	}
+	if len(st.published) != 0 {
+		t.Error("Messages marked as published after the failure")
+	}
+
+	if err := relay.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	msgs := publisher.Messages()
+	if len(msgs) != 5 {
+		t.Fatal(len(msgs))
+	}
+	for i, m := range msgs {
+		if m.ID != uint64(i+1) {
+			t.Error("Messages are published out of order", m.ID)
+		}
+	}
+}
+
+func TestMultiPublisherRetriesAll(t *testing.T) {
+	st := newStorage(3)
+	first := outbox.NewMemoryPublisher()
+	second := &failingPublisher{MemoryPublisher: outbox.NewMemoryPublisher()}
+	relay := outbox.NewRelay(st, outbox.NewMultiPublisher(first, second), 10, logging.NewLogger())
+
+	if err := relay.Flush(context.Background()); err == nil {
+		t.Error("Error of any publisher must be returned")
+	}
+	if len(st.published) != 0 {
+		t.Error("Messages marked as published after the failure")
+	}
+	if err := relay.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Messages()) != 6 || len(second.Messages()) != 3 {
+		t.Errorf("Batch must be published again to every publisher, got %d and %d", len(first.Messages()), len(second.Messages()))
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.ndjson")
+	publisher, err := outbox.NewFilePublisher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newStorage(3)
+	if err := publisher.Publish(context.Background(), st.messages); err != nil {
+		t.Fatal(err)
+	}
+	publisher.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record struct {
+			ID    uint64        `json:"id"`
+			Event *events.Event `json:"event"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		lines++
+		if record.ID != uint64(lines) || record.Event.Amount != float32(lines) {
+			t.Error(record.ID, record.Event)
+		}
+	}
+	if lines != 3 {
+		t.Error(lines)
+	}
+}
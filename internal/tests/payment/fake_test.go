@@ -0,0 +1,87 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"user-balance-service/internal/payment"
+	"user-balance-service/internal/payment/fake"
+	"user-balance-service/pkg/logging"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestFakeProviderCallback(t *testing.T) {
+	var provider *fake.Provider
+	callbacks := make(chan *payment.Callback, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cb, err := provider.ParseCallback(r)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		callbacks <- cb
+	}))
+	defer server.Close()
+
+	provider, err := fake.NewProvider("http://localhost:8080", server.URL, "secret", logging.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp, err := provider.CreatePayment(context.Background(), &payment.Payment{ID: 1, UserId: 1, Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pp.ConfirmationUrl, pp.ID) {
+		t.Error(pp.ConfirmationUrl)
+	}
+
+	err = provider.Complete(context.Background(), pp.ID, payment.StatusSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := <-callbacks
+	if cb.ProviderPaymentId != pp.ID || cb.Status != payment.StatusSucceeded {
+		t.Error(cb)
+	}
+
+	err = provider.Complete(context.Background(), pp.ID, payment.StatusSucceeded)
+	if err == nil {
+		t.Error("Completed payment must not be completed again")
+	}
+}
+
+func TestFakeProviderRejectsTamperedCallback(t *testing.T) {
+	provider, err := fake.NewProvider("http://localhost:8080", "http://localhost:8080/api/payments/callback", "secret", logging.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"payment_id":"1","status":"succeeded"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/payments/callback", strings.NewReader(body))
+	r.Header.Set(fake.SignatureHeader, provider.Sign([]byte(body)))
+	if _, err := provider.ParseCallback(r); err != nil {
+		t.Error(err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/payments/callback", strings.NewReader(strings.Replace(body, "1", "2", 1)))
+	r.Header.Set(fake.SignatureHeader, provider.Sign([]byte(body)))
+	if _, err := provider.ParseCallback(r); err == nil {
+		t.Error("Tampered callback accepted")
+	}
+}
+
+func TestFakeProviderRejectsSampleSecret(t *testing.T) {
+	for _, secret := range []string{"", "fake-provider-secret"} {
+		if _, err := fake.NewProvider("http://localhost:8080", "http://localhost:8080/api/payments/callback", secret, logging.NewLogger()); err == nil {
+			t.Errorf("Provider must not start with secret %q", secret)
+		}
+	}
+}
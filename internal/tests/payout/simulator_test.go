@@ -0,0 +1,228 @@
+package payout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/payout"
+	"user-balance-service/internal/payout/simulator"
+	"user-balance-service/pkg/logging"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestSimulator(t *testing.T) {
+	g := simulator.NewGateway(50*time.Millisecond, 0)
+
+	ok, err := g.Send(context.Background(), &payout.Payout{ID: 1, Amount: 10, Destination: "4111111111111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := g.Send(context.Background(), &payout.Payout{ID: 2, Amount: 10, Destination: "fail-account"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := g.Send(context.Background(), &payout.Payout{ID: 1, Amount: 10, Destination: "4111111111111111"})
+	if err != nil || again != ok {
+		t.Error("Send must be idempotent", again, err)
+	}
+
+	status, err := g.Status(context.Background(), ok)
+	if err != nil || status != payout.StatusProcessing {
+		t.Error(status, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	status, err = g.Status(context.Background(), ok)
+	if err != nil || status != payout.StatusSucceeded {
+		t.Error(status, err)
+	}
+	status, err = g.Status(context.Background(), failed)
+	if err != nil || status != payout.StatusFailed {
+		t.Error(status, err)
+	}
+
+	_, err = g.Status(context.Background(), "unknown")
+	if err == nil {
+		t.Error("Unknown payout must not have a status")
+	}
+}
+
+// storage keeps payouts in memory
+type storage struct {
+	payouts map[uint32]*payout.Payout
+	failed  []uint32
+}
+
+func (s *storage) Hold(ctx context.Context, p *payout.Payout) error {
+	p.ID = uint32(len(s.payouts) + 1)
+	copied := *p
+	s.payouts[p.ID] = &copied
+	return nil
+}
+
+func (s *storage) MarkProcessing(ctx context.Context, id uint32, ref string) error {
+	s.payouts[id].Status = payout.StatusProcessing
+	s.payouts[id].GatewayRef = ref
+	return nil
+}
+
+func (s *storage) Lock(ctx context.Context) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s *storage) MarkResent(ctx context.Context, id uint32, ref string) error {
+	s.payouts[id].GatewayRef = ref
+	s.payouts[id].Resent = true
+	return nil
+}
+
+// RecordAttempt ignores retryAt, so every poll requests the status again
+func (s *storage) RecordAttempt(ctx context.Context, id uint32, retryAt time.Time) (uint32, error) {
+	s.payouts[id].Attempts++
+	return s.payouts[id].Attempts, nil
+}
+
+func (s *storage) Finalize(ctx context.Context, id uint32) error {
+	s.payouts[id].Status = payout.StatusSucceeded
+	return nil
+}
+
+func (s *storage) Fail(ctx context.Context, id uint32) (bool, error) {
+	if s.payouts[id].Status == payout.StatusFailed {
+		return false, nil
+	}
+	s.payouts[id].Status = payout.StatusFailed
+	s.failed = append(s.failed, id)
+	return true, nil
+}
+
+func (s *storage) GetUnfinished(ctx context.Context, now time.Time, limit uint32) ([]*payout.Payout, error) {
+	res := make([]*payout.Payout, 0)
+	for _, p := range s.payouts {
+		if p.Status == payout.StatusPending || p.Status == payout.StatusProcessing {
+			copied := *p
+			res = append(res, &copied)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) GetPayout(ctx context.Context, id uint32) (*payout.Payout, error) {
+	copied := *s.payouts[id]
+	return &copied, nil
+}
+
+// brokenGateway accepts payouts but can not tell their status
+type brokenGateway struct{}
+
+func (brokenGateway) Name() string {
+	return "broken"
+}
+
+func (brokenGateway) Send(ctx context.Context, p *payout.Payout) (string, error) {
+	return fmt.Sprintf("broken-%d", p.ID), nil
+}
+
+func (brokenGateway) Status(ctx context.Context, ref string) (payout.Status, error) {
+	return "", errors.New("gateway is not available")
+}
+
+func TestPollResendsLostPayout(t *testing.T) {
+	st := &storage{payouts: make(map[uint32]*payout.Payout)}
+	s := payout.NewService(st, simulator.NewGateway(0, 0), logging.NewLogger(), events.NewBus())
+	p, err := s.RequestPayout(context.Background(), &payout.Request{ID: 1, Amount: 10, Destination: "4111111111111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the simulator forgets its transfers on restart
+	s = payout.NewService(st, simulator.NewGateway(0, 0), logging.NewLogger(), events.NewBus())
+	if err := s.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := st.payouts[p.ID].Status; status != payout.StatusSucceeded {
+		t.Errorf("Lost payout must be sent again, got %s", status)
+	}
+}
+
+func TestPollKeepsPayoutWithUnknownStatus(t *testing.T) {
+	st := &storage{payouts: make(map[uint32]*payout.Payout)}
+	s := payout.NewService(st, brokenGateway{}, logging.NewLogger(), events.NewBus())
+	p, err := s.RequestPayout(context.Background(), &payout.Request{ID: 1, Amount: 10, Destination: "4111111111111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := s.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := st.payouts[p.ID].Status; status != payout.StatusProcessing {
+		t.Errorf("Payout with unknown status may be already sent and must not fail, got %s", status)
+	}
+	if len(st.failed) != 0 {
+		t.Errorf("Held money must not be returned, got %d returns", len(st.failed))
+	}
+	if attempts := st.payouts[p.ID].Attempts; attempts != 20 {
+		t.Errorf("Every failed status request must be counted, got %d", attempts)
+	}
+}
+
+// forgetfulGateway accepts payouts but never knows them
+type forgetfulGateway struct{}
+
+func (forgetfulGateway) Name() string {
+	return "forgetful"
+}
+
+func (forgetfulGateway) Send(ctx context.Context, p *payout.Payout) (string, error) {
+	return fmt.Sprintf("forgetful-%d", p.ID), nil
+}
+
+func (forgetfulGateway) Status(ctx context.Context, ref string) (payout.Status, error) {
+	return "", apperror.ErrNotFound
+}
+
+func TestPollFailsPayoutLostAfterResend(t *testing.T) {
+	st := &storage{payouts: make(map[uint32]*payout.Payout)}
+	s := payout.NewService(st, forgetfulGateway{}, logging.NewLogger(), events.NewBus())
+	p, err := s.RequestPayout(context.Background(), &payout.Request{ID: 1, Amount: 10, Destination: "4111111111111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := st.payouts[p.ID].Status; status != payout.StatusProcessing || !st.payouts[p.ID].Resent {
+		t.Errorf("Lost payout must be sent again first, got %s", status)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := st.payouts[p.ID].Status; status != payout.StatusFailed {
+		t.Errorf("Payout lost after it was sent again must fail, got %s", status)
+	}
+	if len(st.failed) != 1 {
+		t.Errorf("Held money must be returned once, got %d", len(st.failed))
+	}
+}
@@ -0,0 +1,62 @@
+package period
+
+import (
+	"testing"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	cases := []struct {
+		req        cashaccount.BookkeepingReportRequest
+		start, end time.Time
+	}{
+		{cashaccount.BookkeepingReportRequest{Period: "2022-03"}, date(2022, 3, 1), date(2022, 4, 1)},
+		{cashaccount.BookkeepingReportRequest{Period: "2022-12"}, date(2022, 12, 1), date(2023, 1, 1)},
+		{cashaccount.BookkeepingReportRequest{Period: "2022-Q2"}, date(2022, 4, 1), date(2022, 7, 1)},
+		{cashaccount.BookkeepingReportRequest{Period: "2022-q4"}, date(2022, 10, 1), date(2023, 1, 1)},
+		{cashaccount.BookkeepingReportRequest{Period: "2022", Granularity: cashaccount.GranularityMonth}, date(2022, 1, 1), date(2023, 1, 1)},
+		{cashaccount.BookkeepingReportRequest{From: "2022-03-05", To: "2022-03-05"}, date(2022, 3, 5), date(2022, 3, 6)},
+		{cashaccount.BookkeepingReportRequest{From: "2022-01-15", To: "2022-02-14", Granularity: cashaccount.GranularityWeek}, date(2022, 1, 15), date(2022, 2, 15)},
+	}
+	for _, c := range cases {
+		p, err := cashaccount.ParseReportPeriod(&c.req)
+		if err != nil {
+			t.Errorf("%+v: %s", c.req, err)
+			continue
+		}
+		if !p.Start.Equal(c.start) || !p.End.Equal(c.end) {
+			t.Errorf("%+v: got %s - %s", c.req, p.Start, p.End)
+		}
+		if p.Granularity != c.req.Granularity {
+			t.Errorf("%+v: granularity is lost", c.req)
+		}
+	}
+
+	month, _ := cashaccount.ParseReportPeriod(&cashaccount.BookkeepingReportRequest{Period: "2022-03"})
+	days, _ := cashaccount.ParseReportPeriod(&cashaccount.BookkeepingReportRequest{From: "2022-03-01", To: "2022-03-31"})
+	if month.Key() != days.Key() {
+		t.Error("The same period must have the same key")
+	}
+
+	invalid := []cashaccount.BookkeepingReportRequest{
+		{},
+		{Period: "2022-13"},
+		{Period: "2022-Q5"},
+		{Period: "22-03"},
+		{Period: "2022-03", From: "2022-03-01", To: "2022-03-31"},
+		{From: "2022-03-01"},
+		{From: "2022-03-10", To: "2022-03-01"},
+		{From: "2010-01-01", To: "2022-01-01"},
+		{Period: "2022-03", Granularity: "hour"},
+	}
+	for _, req := range invalid {
+		if _, err := cashaccount.ParseReportPeriod(&req); err == nil {
+			t.Errorf("%+v must be rejected", req)
+		}
+	}
+}
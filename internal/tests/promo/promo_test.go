@@ -0,0 +1,125 @@
+package promo
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"user-balance-service/internal/promo"
+	"user-balance-service/internal/promo/db"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+var (
+	d *sql.DB
+	s *promo.Service
+)
+
+func preparePromo() {
+	logger := logging.NewLogger()
+
+	database, err := mysql.NewClient(
+		context.Background(),
+		"localhost",
+		"3306",
+		"user",
+		"secret",
+		"service-db")
+	if err != nil {
+		panic(err)
+	}
+
+	d = database
+	s = promo.NewService(db.NewStorage(database, logger), logger, nil)
+}
+
+func cleanup() {
+	d.Exec(`delete from promo_redemption;`)
+	d.Exec(`delete from promo_code;`)
+	d.Exec(`delete from main_account;`)
+	d.Exec(`delete from user_report;`)
+}
+
+func TestMain(t *testing.M) {
+	preparePromo()
+	cleanup()
+	t.Run()
+	cleanup()
+	os.RemoveAll("all.log")
+	os.Exit(0)
+}
+
+func TestRedeem(t *testing.T) {
+	err := s.CreatePromoCode(context.Background(), &promo.PromoCode{
+		Code:         "welcome",
+		Amount:       50,
+		UsageLimit:   2,
+		PerUserLimit: 1,
+		ValidTo:      time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Redeem(context.Background(), &promo.Redemption{ID: 1, Code: "WELCOME"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = s.Redeem(context.Background(), &promo.Redemption{ID: 1, Code: "WELCOME"})
+	if err == nil {
+		t.Error("Promo code redeemed twice by the same user")
+	}
+
+	err = s.Redeem(context.Background(), &promo.Redemption{ID: 1, Code: "UNKNOWN"})
+	if err == nil {
+		t.Error("Unknown promo code redeemed")
+	}
+
+	var balance float32
+	r := d.QueryRow(`select balance from main_account where service_user_id = ?`, 1)
+	if err = r.Scan(&balance); err != nil {
+		t.Error(err)
+	}
+	if balance != 50 {
+		t.Error(balance)
+	}
+}
+
+func TestConcurrentRedeem(t *testing.T) {
+	err := s.CreatePromoCode(context.Background(), &promo.PromoCode{
+		Code:         "RACE",
+		Amount:       10,
+		UsageLimit:   1,
+		PerUserLimit: 1,
+		ValidTo:      time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			errs <- s.Redeem(context.Background(), &promo.Redemption{ID: id, Code: "RACE"})
+		}(uint32(i))
+	}
+	wg.Wait()
+	close(errs)
+
+	var succeeded int
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Error(succeeded)
+	}
+}
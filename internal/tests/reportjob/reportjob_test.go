@@ -0,0 +1,294 @@
+package reportjob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/reportjob"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps jobs in memory and deduplicates unfinished jobs like the unique active_key column
+type storage struct {
+	mu       sync.Mutex
+	jobs     []*reportjob.Job
+	keys     []string
+	attempts []uint32
+}
+
+func (s *storage) Create(ctx context.Context, job *reportjob.Job, paramsKey string) (*reportjob.Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.jobs {
+		if s.keys[i] == paramsKey && (j.Status == reportjob.StatusQueued || j.Status == reportjob.StatusRunning) {
+			copied := *j
+			return &copied, false, nil
+		}
+	}
+	job.ID = uint32(len(s.jobs) + 1)
+	job.CreatedAt = time.Now()
+	s.jobs = append(s.jobs, job)
+	s.keys = append(s.keys, paramsKey)
+	s.attempts = append(s.attempts, 0)
+	copied := *job
+	return &copied, true, nil
+}
+
+func (s *storage) Get(ctx context.Context, id uint32) (*reportjob.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 || int(id) > len(s.jobs) {
+		return nil, apperror.ErrNotFound
+	}
+	copied := *s.jobs[id-1]
+	return &copied, nil
+}
+
+func (s *storage) GetQueued(ctx context.Context, limit uint32) ([]uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]uint32, 0)
+	for _, j := range s.jobs {
+		if j.Status == reportjob.StatusQueued && uint32(len(res)) < limit {
+			res = append(res, j.ID)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) Claim(ctx context.Context, id uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs[id-1].Status != reportjob.StatusQueued {
+		return false, nil
+	}
+	s.jobs[id-1].Status = reportjob.StatusRunning
+	s.jobs[id-1].UpdatedAt = time.Now()
+	s.attempts[id-1]++
+	return true, nil
+}
+
+func (s *storage) Heartbeat(ctx context.Context, id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs[id-1].Status == reportjob.StatusRunning {
+		s.jobs[id-1].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *storage) Expire(ctx context.Context, lease time.Duration, maxAttempts uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.jobs {
+		if j.Status != reportjob.StatusRunning || time.Since(j.UpdatedAt) < lease {
+			continue
+		}
+		if s.attempts[i] >= maxAttempts {
+			j.Status = reportjob.StatusFailed
+			j.Error = "The worker stopped while building the report"
+		} else {
+			j.Status = reportjob.StatusQueued
+		}
+	}
+	return nil
+}
+
+func (s *storage) Finish(ctx context.Context, id uint32, reportKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id-1].Status = reportjob.StatusDone
+	s.jobs[id-1].ReportKey = reportKey
+	return nil
+}
+
+func (s *storage) Fail(ctx context.Context, id uint32, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id-1].Status = reportjob.StatusFailed
+	s.jobs[id-1].Error = reason
+	return nil
+}
+
+// generator waits for release before building a report and fails for the periods in failing
+type generator struct {
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+	failing map[string]bool
+}
+
+func (g *generator) CreateBookkeepingReport(ctx context.Context, req *cashaccount.BookkeepingReportRequest) (*cashaccount.BookkeepingReport, error) {
+	<-g.release
+	g.mu.Lock()
+	g.calls++
+	g.mu.Unlock()
+	if g.failing[req.Period] {
+		return nil, errors.New("database is not available")
+	}
+	return &cashaccount.BookkeepingReport{Key: req.Period + "-key", CreatedBy: req.Creator}, nil
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func waitStatus(t *testing.T, s *reportjob.Service, id uint32, status reportjob.Status) *reportjob.Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := s.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %d did not become %s", id, status)
+	return nil
+}
+
+func TestReportJobs(t *testing.T) {
+	st := &storage{}
+	gen := &generator{release: make(chan struct{}), failing: map[string]bool{"2022-04": true}}
+	s := reportjob.NewService(st, gen, 2, time.Minute, logging.NewLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, 50*time.Millisecond)
+
+	first, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-03", Creator: "finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != reportjob.StatusQueued {
+		t.Errorf("New job must be queued, got %s", first.Status)
+	}
+
+	same, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{From: "2022-03-01", To: "2022-03-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.ID != first.ID {
+		t.Error("Identical unfinished jobs must be deduplicated")
+	}
+
+	weekly, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-03", Granularity: cashaccount.GranularityWeek})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weekly.ID == first.ID {
+		t.Error("Jobs with another granularity must not be deduplicated")
+	}
+
+	failing, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-04"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failing.Creator != "api" {
+		t.Errorf("Default creator must be api, got %s", failing.Creator)
+	}
+
+	waitStatus(t, s, first.ID, reportjob.StatusRunning)
+	close(gen.release)
+
+	done := waitStatus(t, s, first.ID, reportjob.StatusDone)
+	if done.ReportKey != "2022-03-key" {
+		t.Errorf("Unexpected report key %s", done.ReportKey)
+	}
+	failed := waitStatus(t, s, failing.ID, reportjob.StatusFailed)
+	if failed.Error == "" {
+		t.Error("Failed job must keep the reason")
+	}
+
+	again, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == first.ID {
+		t.Error("Finished job must not be reused")
+	}
+	waitStatus(t, s, again.ID, reportjob.StatusDone)
+
+	gen.mu.Lock()
+	calls := gen.calls
+	gen.mu.Unlock()
+	if calls != 4 {
+		t.Errorf("Expected 4 reports to be built, got %d", calls)
+	}
+}
+
+func TestReportJobValidation(t *testing.T) {
+	s := reportjob.NewService(&storage{}, &generator{}, 1, time.Minute, logging.NewLogger())
+
+	_, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-13"})
+	if !errors.Is(err, apperror.ErrBadRequest) {
+		t.Errorf("Invalid period must be rejected, got %v", err)
+	}
+	_, err = s.Get(context.Background(), 1)
+	if !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("Unknown job must not be found, got %v", err)
+	}
+}
+
+func TestReportJobOfCrashedWorker(t *testing.T) {
+	st := &storage{}
+	gen := &generator{release: make(chan struct{})}
+	close(gen.release)
+	s := reportjob.NewService(st, gen, 1, 300*time.Millisecond, logging.NewLogger())
+
+	// the workers of another replica claimed the jobs and stopped without finishing them
+	crashed, _, _ := st.Create(context.Background(), &reportjob.Job{BookkeepingReportRequest: cashaccount.BookkeepingReportRequest{Period: "2022-05"}, Status: reportjob.StatusQueued}, "2022-05")
+	exhausted, _, _ := st.Create(context.Background(), &reportjob.Job{BookkeepingReportRequest: cashaccount.BookkeepingReportRequest{Period: "2022-06"}, Status: reportjob.StatusQueued}, "2022-06")
+	for i := 0; i < 3; i++ {
+		st.Claim(context.Background(), exhausted.ID)
+		st.jobs[exhausted.ID-1].Status = reportjob.StatusQueued
+	}
+	st.Claim(context.Background(), exhausted.ID)
+	st.Claim(context.Background(), crashed.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, 50*time.Millisecond)
+
+	if job := waitStatus(t, s, crashed.ID, reportjob.StatusDone); job.ReportKey != "2022-05-key" {
+		t.Errorf("Unexpected report key %s", job.ReportKey)
+	}
+	if job := waitStatus(t, s, exhausted.ID, reportjob.StatusFailed); job.Error == "" {
+		t.Error("Failed job must keep the reason")
+	}
+}
+
+func TestReportJobLeaseIsRenewed(t *testing.T) {
+	st := &storage{}
+	gen := &generator{release: make(chan struct{})}
+	s := reportjob.NewService(st, gen, 2, 150*time.Millisecond, logging.NewLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, 20*time.Millisecond)
+
+	job, err := s.Enqueue(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-07"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, job.ID, reportjob.StatusRunning)
+	// the report is built longer than the lease
+	time.Sleep(500 * time.Millisecond)
+	close(gen.release)
+	waitStatus(t, s, job.ID, reportjob.StatusDone)
+
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	if gen.calls != 1 {
+		t.Errorf("Job of a running worker must not be taken again, got %d reports", gen.calls)
+	}
+}
@@ -0,0 +1,260 @@
+package reportjob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/reportjob"
+	"user-balance-service/internal/webhook"
+	"user-balance-service/pkg/logging"
+)
+
+// scheduleStorage keeps the runs in memory, the lock is shared like the MySQL named lock
+type scheduleStorage struct {
+	mu        sync.Mutex
+	locked    bool
+	runs      []*reportjob.ScheduledRun
+	delivered map[uint32][]string
+}
+
+func (s *scheduleStorage) Lock(ctx context.Context) (func(), bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked {
+		return nil, false, nil
+	}
+	s.locked = true
+	return func() {
+		s.mu.Lock()
+		s.locked = false
+		s.mu.Unlock()
+	}, true, nil
+}
+
+func (s *scheduleStorage) GetScheduledRun(ctx context.Context, period string) (*reportjob.ScheduledRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		if run.Period == period {
+			copied := *run
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *scheduleStorage) SaveScheduledRun(ctx context.Context, run *reportjob.ScheduledRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.ID = uint32(len(s.runs) + 1)
+	copied := *run
+	s.runs = append(s.runs, &copied)
+	return nil
+}
+
+func (s *scheduleStorage) GetDelivered(ctx context.Context, id uint32) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.delivered[id]...), nil
+}
+
+func (s *scheduleStorage) SaveDelivered(ctx context.Context, id uint32, notifier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.delivered == nil {
+		s.delivered = make(map[uint32][]string)
+	}
+	s.delivered[id] = append(s.delivered[id], notifier)
+	return nil
+}
+
+func (s *scheduleStorage) MarkNotified(ctx context.Context, id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[id-1].Notified = true
+	return nil
+}
+
+// receiver accepts the report webhooks, it fails while down is set
+type receiver struct {
+	mu            sync.Mutex
+	down          bool
+	notifications []reportjob.Notification
+	signatures    []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var n reportjob.Notification
+	json.NewDecoder(req.Body).Decode(&n)
+	r.notifications = append(r.notifications, n)
+	r.signatures = append(r.signatures, req.Header.Get(webhook.SignatureHeader))
+}
+
+func TestScheduledReport(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	st := &scheduleStorage{}
+	release := make(chan struct{})
+	close(release)
+	gen := &generator{release: release}
+	recv := &receiver{down: true}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	links, err := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := reportjob.ScheduleOptions{Day: 2, Hour: 6, Minute: 30, Location: moscow}
+	replicas := make([]*reportjob.Scheduler, 0, 2)
+	for i := 0; i < 2; i++ {
+		notifier := reportjob.NewWebhookNotifier(server.URL, "hook-secret", time.Second)
+		scheduler, err := reportjob.NewScheduler(st, gen, links, []reportjob.Notifier{notifier}, options, logging.NewLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, scheduler)
+	}
+
+	// 06:29 in Moscow on the 2nd of April
+	early := time.Date(2022, 4, 2, 3, 29, 0, 0, time.UTC)
+	if err := replicas[0].Tick(context.Background(), early); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.runs) != 0 {
+		t.Fatal("Report must not be generated before the scheduled time")
+	}
+
+	due := time.Date(2022, 4, 2, 3, 30, 0, 0, time.UTC)
+	if err := replicas[0].Tick(context.Background(), due); err == nil {
+		t.Error("Failed notification must be reported")
+	}
+	if err := replicas[1].Tick(context.Background(), due.Add(time.Minute)); err == nil {
+		t.Error("Failed notification must be reported")
+	}
+	if len(st.runs) != 1 || st.runs[0].Period != "2022-03" || st.runs[0].Notified {
+		t.Fatalf("Expected one not notified run for March, got %+v", st.runs)
+	}
+
+	recv.mu.Lock()
+	recv.down = false
+	recv.mu.Unlock()
+	for _, scheduler := range replicas {
+		if err := scheduler.Tick(context.Background(), due.Add(2*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if gen.calls != 1 {
+		t.Errorf("Report must be generated once across the replicas, got %d", gen.calls)
+	}
+	if !st.runs[0].Notified {
+		t.Error("Run must be notified")
+	}
+	if len(recv.notifications) != 1 {
+		t.Fatalf("Expected one notification, got %d", len(recv.notifications))
+	}
+	n := recv.notifications[0]
+	if n.Period != "2022-03" || n.ReportKey != "2022-03-key" || n.Link == "" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+	if recv.signatures[0] == "" {
+		t.Error("Notification must be signed")
+	}
+
+	// a replica started later in the month catches up with the next month only
+	late := time.Date(2022, 4, 20, 0, 0, 0, 0, time.UTC)
+	if err := replicas[1].Tick(context.Background(), late); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicas[1].Tick(context.Background(), time.Date(2022, 5, 2, 4, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.runs) != 2 || st.runs[1].Period != "2022-04" || gen.calls != 2 {
+		t.Errorf("Expected the report for April, got %d runs", len(st.runs))
+	}
+}
+
+// notifier counts notifications and fails while failing is set
+type notifier struct {
+	name    string
+	calls   int
+	failing bool
+}
+
+func (n *notifier) Name() string {
+	return n.name
+}
+
+func (n *notifier) Notify(ctx context.Context, notification *reportjob.Notification) error {
+	n.calls++
+	if n.failing {
+		return errors.New("Notifier is not available")
+	}
+	return nil
+}
+
+func TestScheduledReportRetriesFailedNotifiers(t *testing.T) {
+	st := &scheduleStorage{}
+	release := make(chan struct{})
+	close(release)
+	links, _ := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", time.Hour, nil)
+	email := &notifier{name: "email"}
+	hook := &notifier{name: "webhook", failing: true}
+	scheduler, err := reportjob.NewScheduler(st, &generator{release: release}, links, []reportjob.Notifier{email, hook}, reportjob.ScheduleOptions{Day: 1}, logging.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := scheduler.Tick(context.Background(), due.Add(time.Duration(i)*time.Minute)); err == nil {
+			t.Error("Failed notification must be reported")
+		}
+	}
+	if email.calls != 1 || hook.calls != 3 {
+		t.Errorf("Only the failed notifier must be retried, got email %d, webhook %d", email.calls, hook.calls)
+	}
+
+	hook.failing = false
+	if err := scheduler.Tick(context.Background(), due.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Tick(context.Background(), due.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if email.calls != 1 || hook.calls != 4 || !st.runs[0].Notified {
+		t.Errorf("Run must be notified once by every notifier, got email %d, webhook %d", email.calls, hook.calls)
+	}
+}
+
+func TestSchedulerOptions(t *testing.T) {
+	links, _ := cashaccount.NewLinkSigner("http://localhost:8080", "secret", "token", time.Hour, nil)
+	for _, options := range []reportjob.ScheduleOptions{
+		{Day: 0},
+		{Day: 29},
+		{Day: 1, Granularity: "hour"},
+		{Day: 1, Format: "pdf"},
+	} {
+		if _, err := reportjob.NewScheduler(&scheduleStorage{}, &generator{}, links, nil, options, logging.NewLogger()); err == nil {
+			t.Errorf("Options %+v must be rejected", options)
+		}
+	}
+	if _, _, err := reportjob.ParseScheduleTime("25:00"); err == nil {
+		t.Error("Invalid time must be rejected")
+	}
+	if hour, minute, err := reportjob.ParseScheduleTime("06:30"); err != nil || hour != 6 || minute != 30 {
+		t.Errorf("Unexpected time %d:%d %v", hour, minute, err)
+	}
+}
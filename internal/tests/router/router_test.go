@@ -0,0 +1,46 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-balance-service/internal/handlers"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func route(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		w.Write([]byte(name + " " + params.ByName("id") + " " + params.ByName("period")))
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	router := handlers.NewRouter()
+	router.HandlerFunc(http.MethodGet, "/api/users/balance/:id", route("balance"))
+	router.HandlerFunc(http.MethodPost, "/api/users/reserve/", route("reserve"))
+	router.Dispatch(http.MethodGet, "/api/users/:id/stream", route("stream"))
+	router.Dispatch(http.MethodGet, "/api/users/:id/statements/:period", route("statement"))
+
+	cases := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{http.MethodGet, "/api/users/balance/1", http.StatusOK, "balance 1 "},
+		{http.MethodPost, "/api/users/reserve/", http.StatusOK, "reserve  "},
+		{http.MethodGet, "/api/users/1/stream", http.StatusOK, "stream 1 "},
+		{http.MethodGet, "/api/users/2/statements/2022-01", http.StatusOK, "statement 2 2022-01"},
+		{http.MethodPost, "/api/users/1/stream", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/users//stream", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/users/1/statements", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || c.code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s %s: unexpected response %d %q", c.method, c.path, w.Code, w.Body)
+		}
+	}
+}
@@ -2,14 +2,21 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+	"user-balance-service/internal/apperror"
 	cashaccount "user-balance-service/internal/cash_account"
 	"user-balance-service/internal/cash_account/db"
 	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/filestore"
 	"user-balance-service/pkg/logging"
 )
 
@@ -18,6 +25,9 @@ var (
 	s *cashaccount.Service
 )
 
+// filesDir keeps the report files of the tests
+const filesDir = "files"
+
 func prepareService() {
 	logger := logging.NewLogger()
 
@@ -32,8 +42,13 @@ func prepareService() {
 		panic(err)
 	}
 
+	store, err := filestore.NewLocal(filesDir)
+	if err != nil {
+		panic(err)
+	}
+
 	storage := db.NewStorage(database, logger)
-	service := cashaccount.NewService(storage, logger)
+	service := cashaccount.NewService(storage, logger, cashaccount.BonusFirst, nil, cashaccount.DefaultCSVSeparator, store)
 
 	d = database
 	s = service
@@ -45,9 +60,12 @@ func TestMain(t *testing.M) {
 	d.Exec(`delete from reservation;`)
 	d.Exec(`delete from reserve_account;`)
 	d.Exec(`delete from user_report;`)
+	d.Exec(`delete from closed_period;`)
 	d.Exec(`delete from bookkeeping;`)
+	d.Exec(`delete from revenue_daily;`)
 	t.Run()
 	os.RemoveAll("all.log")
+	os.RemoveAll(filesDir)
 	os.Exit(0)
 }
 
@@ -67,10 +85,15 @@ func TestGetUserReport(t *testing.T) {
 		panic("Cant prepare data")
 	}
 
-	urr, err := s.GetUserReport(context.Background(), uint32(1), 0, 0, "", "")
+	report, err := s.GetUserReport(context.Background(), uint32(1), 0, 0, "", "", "", nil, cashaccount.DefaultLocale)
 	if err != nil {
 		t.Error(err)
 	}
+	urr := report.Rows
+
+	if report.Total != 5 {
+		t.Errorf("Incorrect total %d must be 5", report.Total)
+	}
 
 	if len(urr) != 5 {
 		t.Errorf("Incorrect len of array %d must be 4", len(urr))
@@ -105,10 +128,15 @@ func TestGetUserReport(t *testing.T) {
 		}
 	}
 
-	urr1, err := s.GetUserReport(context.Background(), uint32(1), 1, 3, "", "")
+	report, err = s.GetUserReport(context.Background(), uint32(1), 1, 3, "", "", "", nil, cashaccount.DefaultLocale)
 	if err != nil {
 		t.Error(err)
 	}
+	urr1 := report.Rows
+
+	if report.Total != 5 {
+		t.Errorf("Total must not depend on the page, got %d", report.Total)
+	}
 
 	if len(urr1) != 3 {
 		t.Error(len(urr1))
@@ -120,10 +148,11 @@ func TestGetUserReport(t *testing.T) {
 		}
 	}
 
-	urr2, err := s.GetUserReport(context.Background(), uint32(1), 1, 100, "dateTime", "desc")
+	report, err = s.GetUserReport(context.Background(), uint32(1), 1, 100, "", "dateTime", "desc", nil, cashaccount.DefaultLocale)
 	if err != nil {
 		t.Error(err)
 	}
+	urr2 := report.Rows
 
 	for i := 0; i < len(urr2); i++ {
 		if urr2[i].Amount != compareArray[len(compareArray)-1-i].Amount || urr2[i].Description != compareArray[len(compareArray)-1-i].Description {
@@ -131,10 +160,11 @@ func TestGetUserReport(t *testing.T) {
 		}
 	}
 
-	urr3, err := s.GetUserReport(context.Background(), uint32(1), 1, 3, "amount", "desc")
+	report, err = s.GetUserReport(context.Background(), uint32(1), 1, 3, "", "amount", "desc", nil, cashaccount.DefaultLocale)
 	if err != nil {
 		t.Error(err)
 	}
+	urr3 := report.Rows
 
 	for i := 1; i < len(urr3); i++ {
 		fmt.Println(urr3[i])
@@ -143,10 +173,11 @@ func TestGetUserReport(t *testing.T) {
 		}
 	}
 
-	urr4, err := s.GetUserReport(context.Background(), uint32(1), 1, 5, "amount", "asc")
+	report, err = s.GetUserReport(context.Background(), uint32(1), 1, 5, "", "amount", "asc", nil, cashaccount.DefaultLocale)
 	if err != nil {
 		t.Error(err)
 	}
+	urr4 := report.Rows
 
 	if len(urr4) != 5 {
 		t.Error(len(urr4))
@@ -158,21 +189,283 @@ func TestGetUserReport(t *testing.T) {
 		}
 	}
 
-	urr4, err = s.GetUserReport(context.Background(), uint32(1), 1, 5, "a", "a")
+	_, err = s.GetUserReport(context.Background(), uint32(1), 1, 5, "", "a", "a", nil, cashaccount.DefaultLocale)
 	if err == nil {
 		t.Error("Err must me not nil")
 	}
 
-	urr4, err = s.GetUserReport(context.Background(), uint32(100), 1, 5, "", "")
+	report, err = s.GetUserReport(context.Background(), uint32(100), 1, 5, "", "", "", nil, cashaccount.DefaultLocale)
+	if err != nil {
+		t.Error(err)
+	}
+	urr4 = report.Rows
 
 	if len(urr4) != 0 {
 		t.Error(len(urr4))
 	}
 
+	for _, sort := range [][2]string{{"", ""}, {"amount", "desc"}, {"dateTime", "asc"}} {
+		full, err := s.GetUserReport(context.Background(), uint32(1), 0, 0, "", sort[0], sort[1], nil, cashaccount.DefaultLocale)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages := make([]*cashaccount.UserReportRow, 0)
+		cursor := ""
+		for {
+			page, err := s.GetUserReport(context.Background(), uint32(1), 0, 2, cursor, sort[0], sort[1], nil, cashaccount.DefaultLocale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, page.Rows...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if len(pages) != len(full.Rows) {
+			t.Fatalf("Cursor pages of %v have %d rows, expected %d", sort, len(pages), len(full.Rows))
+		}
+		for i := range pages {
+			if pages[i].ID != full.Rows[i].ID {
+				t.Errorf("Cursor pages of %v differ from the full report at %d", sort, i)
+			}
+		}
+
+		_, err = s.GetUserReport(context.Background(), uint32(1), 0, 2, cursor, "amount", "asc", nil, cashaccount.DefaultLocale)
+		if err == nil {
+			t.Error("Cursor of another order must be rejected")
+		}
+	}
+
+	minAmount := float32(15)
+	report, err = s.GetUserReport(context.Background(), uint32(1), 0, 0, "", "", "", &cashaccount.UserReportFilter{ServiceId: 1, MinAmount: &minAmount}, cashaccount.DefaultLocale)
+	if err != nil {
+		t.Error(err)
+	}
+	if report.Total != 2 || len(report.Rows) != 2 {
+		t.Errorf("Expected reserve and accept rows of the service, got %d", report.Total)
+	}
+
+	report, err = s.GetUserReport(context.Background(), uint32(1), 0, 0, "", "", "", &cashaccount.UserReportFilter{Operation: cashaccount.OperationTransfer, Direction: cashaccount.Debit, CounterpartyId: 2}, cashaccount.DefaultLocale)
+	if err != nil {
+		t.Error(err)
+	}
+	if report.Total != 1 || report.Rows[0].Amount != 40 {
+		t.Errorf("Expected the transfer to user 2, got %d rows", report.Total)
+	}
+
+	_, err = s.GetUserReport(context.Background(), uint32(1), 0, 0, "", "", "", &cashaccount.UserReportFilter{Operation: "unknown"}, cashaccount.DefaultLocale)
+	if err == nil {
+		t.Error("Unknown operation type must be rejected")
+	}
+
 	d.Exec(`delete from main_account;`)
 	d.Exec(`delete from reservation;`)
 	d.Exec(`delete from reserve_account;`)
 	d.Exec(`delete from user_report;`)
 	d.Exec(`delete from bookkeeping;`)
+	d.Exec(`delete from revenue_daily;`)
+
+}
+
+func TestBookkeepingReportHistory(t *testing.T) {
+
+	first, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-03", Creator: "finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2022-04"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Key == second.Key {
+		t.Error("Every report must have its own key")
+	}
+	if first.Hash != second.Hash || first.Path != second.Path {
+		t.Error("Reports with the same content must share the file")
+	}
+
+	for _, report := range []*cashaccount.BookkeepingReport{first, second} {
+		saved, err := s.GetBookkeepingReport(context.Background(), report.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Path != report.Path || !saved.PeriodStart.Equal(report.PeriodStart) {
+			t.Errorf("Report %s is not kept", report.Key)
+		}
+		if _, err := os.Stat(filepath.Join(filesDir, saved.Path)); err != nil {
+			t.Errorf("File of the report %s is removed", report.Key)
+		}
+	}
+	if second.CreatedBy != "api" || first.CreatedBy != "finance" {
+		t.Errorf("Unexpected creators %s and %s", first.CreatedBy, second.CreatedBy)
+	}
+
+	reports, err := s.ListBookkeepingReports(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) < 2 || reports[0].Key != second.Key {
+		t.Error("Reports must be listed from the latest")
+	}
+
+	d.Exec(`delete from bookkeeping_report;`)
+}
+
+func TestBookkeepingReportIntegrity(t *testing.T) {
+	d.Exec(`insert into bookkeeping (service_id, amount, created_at) values (1, 10, '2020-05-01 10:00:00');`)
+	if _, err := db.RebuildRevenueDaily(context.Background(), d, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2020-05"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(filesDir, report.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if report.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Hash %s is not the SHA-256 of the content", report.Hash)
+	}
+	if filepath.Base(report.Path) != report.Hash+".csv" {
+		t.Errorf("File %s is not named by its hash", report.Path)
+	}
+
+	byHash, err := s.GetBookkeepingReport(context.Background(), report.Hash)
+	if err != nil || byHash.Key != report.Key {
+		t.Errorf("Report must be found by its hash, got %v", err)
+	}
+
+	verification, err := s.VerifyBookkeepingReport(context.Background(), report.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Valid || verification.ActualHash != report.Hash {
+		t.Error("Untouched report must be valid")
+	}
+	_, served, err := s.OpenBookkeepingReport(context.Background(), report.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servedContent, _ := io.ReadAll(served); string(servedContent) != string(content) {
+		t.Error("Served content differs from the file")
+	}
+
+	if err := os.WriteFile(filepath.Join(filesDir, report.Path), []byte("service_id;amount\n1;1000000.00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verification, err = s.VerifyBookkeepingReport(context.Background(), report.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Valid {
+		t.Error("Tampered report must not be valid")
+	}
+	_, _, err = s.OpenBookkeepingReport(context.Background(), report.Key)
+	if !errors.Is(err, apperror.ErrConflict) {
+		t.Errorf("Tampered report must not be served, got %v", err)
+	}
+
+	d.Exec(`delete from bookkeeping;`)
+	d.Exec(`delete from revenue_daily;`)
+	d.Exec(`delete from bookkeeping_report;`)
+}
+
+func TestBookkeepingReportGranularity(t *testing.T) {
+	d.Exec(`insert into bookkeeping (service_id, amount, created_at) values (1, 10, '2021-02-01 10:00:00'), (1, 5, '2021-02-01 12:00:00'), (2, 7, '2021-02-03 09:00:00'), (1, 3, '2021-03-01 00:00:00');`)
+	if _, err := db.RebuildRevenueDaily(context.Background(), d, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{From: "2021-02-01", To: "2021-02-28", Granularity: cashaccount.GranularityDay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(filesDir, report.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "period;service_id;amount\n2021-02-01;1;15.00\n2021-02-03;2;7.00\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected daily report %q", content)
+	}
+
+	report, err = s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2021-Q1", Format: cashaccount.ReportCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = os.ReadFile(filepath.Join(filesDir, report.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "service_id;amount\n1;18.00\n2;7.00\n" {
+		t.Errorf("Unexpected quarter report %q", content)
+	}
+	if !report.PeriodEnd.Equal(time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Quarter must end on April 1, got %s", report.PeriodEnd)
+	}
+
+	_, err = s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2021-02", Granularity: "hour"})
+	if err == nil {
+		t.Error("Unknown granularity must be rejected")
+	}
+
+	d.Exec(`delete from bookkeeping;`)
+	d.Exec(`delete from revenue_daily;`)
+	d.Exec(`delete from bookkeeping_report;`)
+}
+
+func TestClosedPeriodIsFrozen(t *testing.T) {
+	d.Exec(`insert into bookkeeping (service_id, amount, created_at) values (1, 10, '2019-06-10 10:00:00'), (2, 4, '2019-06-20 10:00:00');`)
+	if _, err := db.RebuildRevenueDaily(context.Background(), d, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2019-06"})
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	if _, err := d.Exec(`insert into closed_period (period, period_start, period_end, closed_by) values ('2019-06', '2019-06-01', '2019-07-01', 'test');`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`insert into bookkeeping (service_id, amount, created_at) values (1, 100, '2019-06-15 10:00:00');`); err == nil {
+		t.Error("Revenue must not be added to a closed period")
+	}
+	if _, err := d.Exec(`update bookkeeping set amount = 100 where created_at = '2019-06-10 10:00:00';`); err == nil {
+		t.Error("Revenue of a closed period must not be changed")
+	}
+	if _, err := d.Exec(`delete from bookkeeping where created_at = '2019-06-20 10:00:00';`); err == nil {
+		t.Error("Revenue of a closed period must not be removed")
+	}
+
+	if _, err := d.Exec(`insert into revenue_daily (day, service_id, revenue, orders) values ('2019-06-15', 1, 100, 1);`); err == nil {
+		t.Error("Rollup must not be added to a closed period")
+	}
+	if _, err := d.Exec(`update revenue_daily set revenue = 0 where day = '2019-06-10';`); err == nil {
+		t.Error("Rollup of a closed period must not be changed")
+	}
+	if _, err := d.Exec(`delete from revenue_daily where day = '2019-06-20';`); err == nil {
+		t.Error("Rollup of a closed period must not be removed")
+	}
+	if _, err := db.RebuildRevenueDaily(context.Background(), d, time.Time{}, time.Time{}); err != nil {
+		t.Errorf("Rebuild must skip the closed period, got %s", err)
+	}
+
+	after, err := s.CreateBookkeepingReport(context.Background(), &cashaccount.BookkeepingReportRequest{Period: "2019-06"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Hash != before.Hash {
+		t.Error("Report of a closed period must be reproducible")
+	}
+
+	d.Exec(`delete from closed_period;`)
+	d.Exec(`delete from bookkeeping;`)
+	d.Exec(`delete from revenue_daily;`)
+	d.Exec(`delete from bookkeeping_report;`)
 }
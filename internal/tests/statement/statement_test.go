@@ -0,0 +1,119 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+	"user-balance-service/internal/apperror"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/statement"
+	"user-balance-service/pkg/filestore"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps statements in memory, users 1 and 2 had operations in every month
+type storage struct {
+	statements []*statement.Statement
+}
+
+func (s *storage) Lock(ctx context.Context) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s *storage) GetPendingUsers(ctx context.Context, period string, from, to time.Time) ([]uint32, error) {
+	res := make([]uint32, 0)
+	for _, id := range []uint32{1, 2} {
+		if _, err := s.Get(ctx, id, period); err != nil {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) Save(ctx context.Context, st *statement.Statement) error {
+	st.ID = uint32(len(s.statements) + 1)
+	s.statements = append(s.statements, st)
+	return nil
+}
+
+func (s *storage) List(ctx context.Context, userId uint32) ([]*statement.Statement, error) {
+	res := make([]*statement.Statement, 0)
+	for _, st := range s.statements {
+		if st.UserId == userId {
+			res = append(res, st)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) Get(ctx context.Context, userId uint32, period string) (*statement.Statement, error) {
+	for _, st := range s.statements {
+		if st.UserId == userId && st.Period == period {
+			return st, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+// exporter writes the period of the filter, it fails for the users in failing
+type exporter struct {
+	failing map[uint32]bool
+}
+
+func (e *exporter) ExportUserReport(ctx context.Context, uid uint32, format cashaccount.ExportFormat, filter *cashaccount.UserReportFilter, locale cashaccount.Locale, w io.Writer) error {
+	if e.failing[uid] {
+		return errors.New("database is not available")
+	}
+	_, err := fmt.Fprintf(w, "%d %s %s", uid, filter.From.Format("2006-01-02"), filter.To.Format("2006-01-02"))
+	return err
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func TestGenerate(t *testing.T) {
+	store, err := filestore.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &storage{}
+	exp := &exporter{failing: map[uint32]bool{2: true}}
+	service := statement.NewService(st, exp, store, cashaccount.ExportCSV, cashaccount.English, logging.NewLogger())
+
+	month := time.Date(2022, 10, 15, 0, 0, 0, 0, time.UTC)
+	n, err := service.Generate(context.Background(), month)
+	if err == nil || n != 1 {
+		t.Errorf("Expected one statement and an error, got %d and %v", n, err)
+	}
+
+	// the failed statement is generated on the next run, the existing one is not repeated
+	exp.failing = nil
+	n, err = service.Generate(context.Background(), month)
+	if err != nil || n != 1 {
+		t.Errorf("Expected one more statement, got %d and %v", n, err)
+	}
+
+	_, content, err := service.Open(context.Background(), 2, "2022-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	data, _ := io.ReadAll(content)
+	if string(data) != "2 2022-10-01 2022-11-01" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	if _, _, err := service.Open(context.Background(), 1, "2022-09"); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("Missing statement must not be found, got %v", err)
+	}
+	if _, _, err := service.Open(context.Background(), 1, "october"); !errors.Is(err, apperror.ErrBadRequest) {
+		t.Errorf("Invalid period must be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+	"user-balance-service/internal/analytics"
+	analyticsdb "user-balance-service/internal/analytics/db"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/cash_account/db"
+	"user-balance-service/pkg/logging"
+)
+
+var analyticsMonth = time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// prepareAnalytics writes the bookkeeping of March 2019 and rolls it up: service 1 earns 160 in 3 orders
+// after the adjustment of February, service 2 earns 30 in 1 order, users 1, 2 and 3 pay
+func prepareAnalytics(t *testing.T) analytics.Storage {
+	cleanAnalytics()
+	_, err := d.Exec(`insert into bookkeeping (service_user_id, service_id, amount, adjusts_period, created_at) values
+		(1, 1, 100, null, '2019-03-01 10:00:00'), (2, 1, 50, null, '2019-03-01 18:00:00'), (1, 2, 30, null, '2019-03-02 09:00:00'),
+		(3, 1, 20, null, '2019-03-05 12:00:00'), (null, 1, -10, '2019-02', '2019-03-05 13:00:00'), (4, 1, 70, null, '2019-04-01 00:00:00');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RebuildRevenueDaily(context.Background(), d, analyticsMonth, analyticsMonth.AddDate(0, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	return analyticsdb.NewStorage(d, logging.NewLogger())
+}
+
+func cleanAnalytics() {
+	d.Exec(`delete from bookkeeping where created_at >= '2019-03-01' and created_at < '2019-05-01';`)
+	d.Exec(`delete from revenue_daily where day >= '2019-03-01' and day < '2019-05-01';`)
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+func TestAnalyticsTotals(t *testing.T) {
+	st := prepareAnalytics(t)
+	defer cleanAnalytics()
+
+	filter := &analytics.Filter{Start: analyticsMonth, End: analyticsMonth.AddDate(0, 1, 0)}
+	totals, err := st.Totals(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(totals.Revenue, 190) || totals.Orders != 4 || totals.PayingUsers != 3 || !near(totals.AverageCheck, 47.5) {
+		t.Errorf("Wrong totals of the month: %+v", totals)
+	}
+
+	filter.ServiceId = 2
+	totals, err = st.Totals(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(totals.Revenue, 30) || totals.Orders != 1 || totals.PayingUsers != 1 {
+		t.Errorf("Wrong totals of the service: %+v", totals)
+	}
+}
+
+func TestAnalyticsRevenueWithoutGranularity(t *testing.T) {
+	st := prepareAnalytics(t)
+	defer cleanAnalytics()
+
+	filter := &analytics.Filter{Start: analyticsMonth, End: analyticsMonth.AddDate(0, 1, 0), Granularity: cashaccount.GranularityNone}
+	points, err := st.Revenue(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("The whole range must be one period per service, got %d points", len(points))
+	}
+	for _, p := range points {
+		if !p.Period.Equal(analyticsMonth) {
+			t.Errorf("Period must be the start of the range, got %s", p.Period)
+		}
+	}
+	if points[0].ServiceId != 1 || !near(points[0].Revenue, 160) || points[0].Orders != 3 {
+		t.Errorf("Wrong revenue of service 1: %+v", points[0])
+	}
+	if points[1].ServiceId != 2 || !near(points[1].Revenue, 30) || points[1].Orders != 1 {
+		t.Errorf("Wrong revenue of service 2: %+v", points[1])
+	}
+
+	users, err := st.PayingUsers(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Users != 3 || !users[0].Period.Equal(analyticsMonth) {
+		t.Errorf("Wrong paying users of the range: %+v", users)
+	}
+}
+
+func TestAnalyticsRevenueByDay(t *testing.T) {
+	st := prepareAnalytics(t)
+	defer cleanAnalytics()
+
+	filter := &analytics.Filter{Start: analyticsMonth, End: analyticsMonth.AddDate(0, 1, 0), Granularity: cashaccount.GranularityDay, ServiceId: 1}
+	points, err := st.Revenue(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || !points[0].Period.Equal(analyticsMonth) || !near(points[0].Revenue, 150) || points[0].Orders != 2 {
+		t.Fatalf("Wrong daily revenue of service 1: %+v", points)
+	}
+	if !points[1].Period.Equal(analyticsMonth.AddDate(0, 0, 4)) || !near(points[1].Revenue, 10) || points[1].Orders != 1 {
+		t.Errorf("Adjustment must change the revenue but not the orders: %+v", points[1])
+	}
+
+	checks, err := st.AverageCheck(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || !near(checks[0].Average, 75) || !near(checks[1].Average, 10) {
+		t.Errorf("Wrong average check: %+v", checks)
+	}
+}
+
+func TestAnalyticsTopServices(t *testing.T) {
+	st := prepareAnalytics(t)
+	defer cleanAnalytics()
+
+	filter := &analytics.Filter{Start: analyticsMonth, End: analyticsMonth.AddDate(0, 1, 0), Limit: 10}
+	top, err := st.TopServices(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("Services of the month only, got %+v", top)
+	}
+	if top[0].ServiceId != 1 || !near(top[0].Revenue, 160) || top[0].Orders != 3 || !near(top[0].Share, 160.0/190) {
+		t.Errorf("Wrong top service: %+v", top[0])
+	}
+	if top[1].ServiceId != 2 || !near(top[1].Revenue, 30) || !near(top[1].Share, 30.0/190) {
+		t.Errorf("Wrong second service: %+v", top[1])
+	}
+
+	// the filter arguments are passed to the query twice, for the services and for the total
+	filter.ServiceId = 2
+	filter.Limit = 1
+	top, err = st.TopServices(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0].ServiceId != 2 || !near(top[0].Share, 1) {
+		t.Errorf("Share of the only selected service must be whole, got %+v", top)
+	}
+}
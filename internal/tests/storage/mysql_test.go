@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"os"
+	"strings"
 	"testing"
+	"time"
 	cashaccount "user-balance-service/internal/cash_account"
 	"user-balance-service/internal/cash_account/db"
 	"user-balance-service/pkg/client/mysql"
@@ -45,6 +47,7 @@ func TestTopUpMoney(t *testing.T) {
 	d.Exec(`delete from main_account;`)
 	d.Exec(`delete from reservation;`)
 	d.Exec(`delete from reserve_account;`)
+	d.Exec(`delete from bonus_account;`)
 	data := &cashaccount.UserAmount{
 		ID:     999,
 		Amount: 100,
@@ -214,20 +217,20 @@ func TestReserve(t *testing.T) {
 		OrderId:   1,
 		Amount:    10,
 	}
-	err = s.ReserveMoney(context.Background(), data)
+	err = s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst)
 	if err == nil {
 		t.Error("User not exist")
 	}
 
 	data.ID = 1
 	data.Amount = 200
-	err = s.ReserveMoney(context.Background(), data)
+	err = s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst)
 	if err == nil {
 		t.Error("Amount too much")
 	}
 
 	data.Amount = 10
-	err = s.ReserveMoney(context.Background(), data)
+	err = s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst)
 	if err != nil {
 		t.Error(err)
 	}
@@ -267,7 +270,7 @@ func TestAcceptRevenue(t *testing.T) {
 		OrderId:   1,
 		Amount:    10,
 	}
-	err = s.ReserveMoney(context.Background(), data)
+	err = s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst)
 	if err != nil {
 		panic(err)
 	}
@@ -317,4 +320,167 @@ func TestAcceptRevenue(t *testing.T) {
 	if balance != 0 {
 		t.Error(balance)
 	}
+
+	var revenue float32
+	var orders int
+	r = d.QueryRow(`select revenue, orders from revenue_daily where day = (select date(max(created_at)) from bookkeeping) and service_id = ?`, data.ServiceId)
+	if err = r.Scan(&revenue, &orders); err != nil {
+		t.Error(err)
+	}
+	if revenue < 10 || orders < 1 {
+		t.Errorf("Accepted revenue is not added to the daily rollup: %f, %d", revenue, orders)
+	}
+}
+
+func TestReserveWithBonus(t *testing.T) {
+	d.Exec(`delete from main_account;`)
+	d.Exec(`delete from reserve_account;`)
+	d.Exec(`delete from reservation;`)
+	err := s.TopUpMoney(context.Background(), &cashaccount.UserAmount{ID: 1, Amount: 100})
+	if err != nil {
+		panic(err)
+	}
+	expiresAt := time.Now().Add(24 * time.Hour)
+	err = s.TopUpBonus(context.Background(), &cashaccount.BonusAmount{ID: 1, Amount: 30, ExpiresAt: expiresAt})
+	if err != nil {
+		panic(err)
+	}
+
+	ua, err := s.GetAmount(context.Background(), 1)
+	if err != nil {
+		t.Error(err)
+	}
+	if ua.Amount != 100 || ua.Bonus != 30 {
+		t.Error(ua.Amount, ua.Bonus)
+	}
+
+	data := &cashaccount.ReserveDetails{
+		ID:        1,
+		ServiceId: 1,
+		OrderId:   1,
+		Amount:    20,
+	}
+	err = s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst)
+	if err != nil {
+		t.Error(err)
+	}
+
+	data.OrderId = 2
+	err = s.ReserveMoney(context.Background(), data, cashaccount.MainFirst)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ua, err = s.GetAmount(context.Background(), 1)
+	if err != nil {
+		t.Error(err)
+	}
+	if ua.Amount != 80 || ua.Bonus != 10 {
+		t.Error(ua.Amount, ua.Bonus)
+	}
+
+	err = s.TopUpMoney(context.Background(), &cashaccount.UserAmount{ID: 2, Amount: 10})
+	if err != nil {
+		panic(err)
+	}
+	err = s.TransferBetweenUsers(context.Background(), &cashaccount.MoneyTransferDetails{FromId: 1, ToId: 2, Amount: 85})
+	if err == nil {
+		t.Error("Bonuses must not be transferable")
+	}
+
+	d.Exec(`delete from main_account where service_user_id = ?;`, data.ID)
+	d.Exec(`delete from reserve_account where service_user_id = ?;`, data.ID)
+	d.Exec(`delete from reservation where service_user_id = ?;`, data.ID)
+	d.Exec(`delete from main_account where service_user_id = ?;`, 2)
+	d.Exec(`delete from bonus_account where service_user_id = ?;`, data.ID)
+}
+
+func TestCancelReturnsBonusExpiry(t *testing.T) {
+	d.Exec(`delete from reservation where service_user_id = ?;`, 1)
+	d.Exec(`delete from bonus_account where service_user_id = ?;`, 1)
+	soon := time.Now().Add(time.Hour).Truncate(time.Second)
+	later := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	if err := s.TopUpBonus(context.Background(), &cashaccount.BonusAmount{ID: 1, Amount: 10, ExpiresAt: soon}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TopUpBonus(context.Background(), &cashaccount.BonusAmount{ID: 1, Amount: 10, ExpiresAt: later}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := &cashaccount.ReserveDetails{ID: 1, ServiceId: 1, OrderId: 3, Amount: 15}
+	if err := s.ReserveMoney(context.Background(), data, cashaccount.BonusFirst); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CancelReservation(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := d.QueryRow(`select count(id) from bonus_account where service_user_id = ?;`, 1).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Bonuses must be returned to the bonuses they were taken from, got %d bonuses", count)
+	}
+	var balance float32
+	if err := d.QueryRow(`select balance from bonus_account where service_user_id = ? and expires_at = ?;`, 1, soon).Scan(&balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance != 10 {
+		t.Errorf("Bonus expiring first must get its portion back, got %f", balance)
+	}
+
+	d.Exec(`delete from bonus_account where service_user_id = ?;`, 1)
+}
+
+func TestOutbox(t *testing.T) {
+	var before, after int
+	if err := d.QueryRow(`select count(id) from outbox where service_user_id = ?;`, 1).Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.TopUpMoney(context.Background(), &cashaccount.UserAmount{ID: 1, Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WithdrawMoney(context.Background(), &cashaccount.UserAmount{ID: 1, Amount: 1000})
+	if err == nil {
+		t.Error("Withdraw too much money")
+	}
+
+	if err := d.QueryRow(`select count(id) from outbox where service_user_id = ?;`, 1).Scan(&after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before+1 {
+		t.Error("Outbox must contain only committed changes", before, after)
+	}
+
+	var eventType string
+	if err := d.QueryRow(`select event_type from outbox where service_user_id = ? order by id desc limit 1;`, 1).Scan(&eventType); err != nil {
+		t.Fatal(err)
+	}
+	if eventType != "accrual" {
+		t.Error(eventType)
+	}
+
+	err = s.TopUpMoney(context.Background(), &cashaccount.UserAmount{ID: 2, Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.TransferBetweenUsers(context.Background(), &cashaccount.MoneyTransferDetails{FromId: 1, ToId: 2, Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for userId, direction := range map[uint32]string{1: "debit", 2: "credit"} {
+		var payload string
+		if err := d.QueryRow(`select payload from outbox where service_user_id = ? order by id desc limit 1;`, userId).Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(payload, `"type":"transfer"`) || !strings.Contains(payload, `"direction":"`+direction+`"`) {
+			t.Errorf("Transfer must be written to the outbox of the user %d, got %s", userId, payload)
+		}
+	}
+
+	d.Exec(`delete from main_account where service_user_id = ?;`, 1)
+	d.Exec(`delete from main_account where service_user_id = ?;`, 2)
 }
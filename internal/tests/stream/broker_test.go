@@ -0,0 +1,66 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"testing"
+	cashaccount "user-balance-service/internal/cash_account"
+	"user-balance-service/internal/events"
+)
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func received(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	broker := cashaccount.NewBroker()
+	first, unsubscribeFirst := broker.Subscribe(1)
+	second, unsubscribeSecond := broker.Subscribe(1)
+	other, unsubscribeOther := broker.Subscribe(2)
+	defer unsubscribeSecond()
+	defer unsubscribeOther()
+
+	broker.Handle(context.Background(), &events.Event{Type: events.Accrual, UserId: 1, Amount: 10})
+	// the second notification is coalesced with the first one
+	broker.Handle(context.Background(), &events.Event{Type: events.Accrual, UserId: 1, Amount: 10})
+
+	if !received(first) || !received(second) {
+		t.Error("All subscribers of the user must be notified")
+	}
+	if received(first) {
+		t.Error("Pending notifications must be coalesced")
+	}
+	if received(other) {
+		t.Error("Subscribers of other users must not be notified")
+	}
+
+	broker.Handle(context.Background(), &events.Event{Type: events.Transfer, UserId: 3, CounterpartyId: 2, Amount: 5})
+	if !received(other) {
+		t.Error("Receiver of a transfer must be notified")
+	}
+
+	broker.Handle(context.Background(), &events.Event{Type: events.Transfer, UserId: 3, CounterpartyId: 2, Direction: events.Debit, Amount: 5})
+	if received(other) {
+		t.Error("Debit side of a transfer must not notify the receiver")
+	}
+
+	unsubscribeFirst()
+	if broker.Subscribers(1) != 1 {
+		t.Errorf("Expected 1 subscriber after unsubscribe, got %d", broker.Subscribers(1))
+	}
+	broker.Handle(context.Background(), &events.Event{Type: events.Withdraw, UserId: 1, Amount: 1})
+	if received(first) {
+		t.Error("Unsubscribed channel must not be notified")
+	}
+}
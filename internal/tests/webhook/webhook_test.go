@@ -0,0 +1,279 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/outbox"
+	"user-balance-service/internal/webhook"
+	"user-balance-service/pkg/logging"
+)
+
+// storage keeps subscriptions and deliveries in memory
+type storage struct {
+	mu         sync.Mutex
+	subs       []*webhook.Subscription
+	deliveries []*webhook.Delivery
+	queued     map[uint64]bool
+}
+
+func (s *storage) CreateSubscription(ctx context.Context, sub *webhook.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.ID = uint32(len(s.subs) + 1)
+	s.subs = append(s.subs, sub)
+	return nil
+}
+
+func (s *storage) GetSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	return s.subs, nil
+}
+
+func (s *storage) DeleteSubscription(ctx context.Context, id uint32) error {
+	return nil
+}
+
+func (s *storage) Lock(ctx context.Context) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s *storage) CreateDeliveries(ctx context.Context, messageId uint64, eventType events.Type, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued == nil {
+		s.queued = make(map[uint64]bool)
+	}
+	if s.queued[messageId] {
+		return nil
+	}
+	s.queued[messageId] = true
+	for _, sub := range s.subs {
+		for _, t := range sub.Events {
+			if t == eventType {
+				s.deliveries = append(s.deliveries, &webhook.Delivery{
+					ID:             uint32(len(s.deliveries) + 1),
+					SubscriptionId: sub.ID,
+					EventType:      eventType,
+					Payload:        payload,
+					Status:         webhook.DeliveryPending,
+					NextAttemptAt:  time.Now(),
+					Url:            sub.Url,
+					Secret:         sub.Secret,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func (s *storage) GetDueDeliveries(ctx context.Context, now time.Time, limit uint32) ([]*webhook.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*webhook.Delivery, 0)
+	for _, d := range s.deliveries {
+		if d.Status == webhook.DeliveryPending && !d.NextAttemptAt.After(now) {
+			item := *d
+			res = append(res, &item)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) MarkDelivered(ctx context.Context, id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[id-1].Status = webhook.DeliveryDelivered
+	return nil
+}
+
+func (s *storage) MarkFailed(ctx context.Context, d *webhook.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := *d
+	s.deliveries[d.ID-1] = &item
+	return nil
+}
+
+func (s *storage) GetDeadDeliveries(ctx context.Context) ([]*webhook.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*webhook.Delivery, 0)
+	for _, d := range s.deliveries {
+		if d.Status == webhook.DeliveryDead {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) Redeliver(ctx context.Context, id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[id-1].Status = webhook.DeliveryPending
+	s.deliveries[id-1].Attempts = 0
+	s.deliveries[id-1].NextAttemptAt = time.Now()
+	return nil
+}
+
+func TestMain(t *testing.M) {
+	code := t.Run()
+	os.RemoveAll("all.log")
+	os.Exit(code)
+}
+
+func message(id uint64, e *events.Event) *outbox.Message {
+	payload, _ := json.Marshal(e)
+	return &outbox.Message{ID: id, UserId: e.UserId, Type: e.Type, Payload: payload}
+}
+
+func TestBackoff(t *testing.T) {
+	if d := webhook.Backoff(1, time.Second, time.Minute); d != time.Second {
+		t.Error(d)
+	}
+	if d := webhook.Backoff(4, time.Second, time.Minute); d != 8*time.Second {
+		t.Error(d)
+	}
+	if d := webhook.Backoff(20, time.Second, time.Minute); d != time.Minute {
+		t.Error(d)
+	}
+}
+
+func TestDeliveryWithRetries(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	var secret string
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign(secret, timestamp, body) {
+			t.Error("Wrong signature")
+		}
+		if fail {
+			w.WriteHeader(503)
+		}
+	}))
+	defer server.Close()
+
+	st := &storage{}
+	s := webhook.NewService(st, webhook.Options{
+		MaxAttempts: 2,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		Timeout:     time.Second,
+	}, logging.NewLogger())
+
+	sub := &webhook.Subscription{Url: server.URL, Events: []events.Type{events.Accrual}}
+	if err := s.CreateSubscription(context.Background(), sub); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	secret = sub.Secret
+	mu.Unlock()
+
+	msgs := []*outbox.Message{
+		message(1, &events.Event{Type: events.Withdraw, UserId: 1, Amount: 10}),
+		message(2, &events.Event{Type: events.Accrual, UserId: 1, Amount: 10}),
+	}
+	// the relay publishes the batch again if it could not mark it as published
+	for i := 0; i < 2; i++ {
+		if err := s.Publish(context.Background(), msgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(st.deliveries) != 1 {
+		t.Fatalf("Message published again must not be queued twice, got %d deliveries", len(st.deliveries))
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Dispatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	dead, _ := s.GetDeadDeliveries(context.Background())
+	if len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatal(dead)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if err := s.Redeliver(context.Background(), dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, _ = s.GetDeadDeliveries(context.Background())
+	if len(dead) != 0 || st.deliveries[0].Status != webhook.DeliveryDelivered {
+		t.Error(st.deliveries[0])
+	}
+	if calls != 3 {
+		t.Error(calls)
+	}
+}
+
+func TestSlowSubscriberDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	var mu sync.Mutex
+	var fastCalls int
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fastCalls++
+		mu.Unlock()
+	}))
+	defer fast.Close()
+
+	st := &storage{}
+	s := webhook.NewService(st, webhook.Options{
+		MaxAttempts: 2,
+		BaseBackoff: time.Minute,
+		MaxBackoff:  time.Minute,
+		Timeout:     200 * time.Millisecond,
+		Concurrency: 2,
+	}, logging.NewLogger())
+	for _, url := range []string{slow.URL, fast.URL, fast.URL} {
+		if err := s.CreateSubscription(context.Background(), &webhook.Subscription{Url: url, Events: []events.Type{events.Accrual}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Publish(context.Background(), []*outbox.Message{message(1, &events.Event{Type: events.Accrual, UserId: 1, Amount: 10})}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := s.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+		t.Errorf("Deliveries must be sent concurrently, dispatch took %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fastCalls != 2 {
+		t.Errorf("Fast subscribers must receive the event, got %d calls", fastCalls)
+	}
+	if st.deliveries[0].Status != webhook.DeliveryPending || st.deliveries[0].Attempts != 1 {
+		t.Errorf("Delivery to the slow subscriber must be retried, got %+v", st.deliveries[0])
+	}
+}
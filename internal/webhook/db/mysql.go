@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/events"
+	"user-balance-service/internal/webhook"
+	"user-balance-service/pkg/client/mysql"
+	"user-balance-service/pkg/logging"
+)
+
+const dispatchLock = "webhook-dispatch"
+
+type db struct {
+	*sql.DB
+	logger *logging.Logger
+}
+
+func joinEvents(types []events.Type) string {
+	res := make([]string, 0, len(types))
+	for _, t := range types {
+		res = append(res, string(t))
+	}
+	return strings.Join(res, ",")
+}
+
+func splitEvents(s string) []events.Type {
+	res := make([]events.Type, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			res = append(res, events.Type(t))
+		}
+	}
+	return res
+}
+
+func (d *db) CreateSubscription(ctx context.Context, sub *webhook.Subscription) error {
+	r, err := d.ExecContext(ctx, `insert into webhook_subscription (url, events, secret) values (?, ?, ?);`, sub.Url, joinEvents(sub.Events), sub.Secret)
+	if err != nil {
+		d.logger.Errorf("Error %s in creating webhook subscription to %s", err, sub.Url)
+		return err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	sub.ID = uint32(id)
+	d.logger.Infof("Created webhook subscription %d to %s", sub.ID, sub.Url)
+	return d.QueryRow(`select created_at from webhook_subscription where id = ?;`, sub.ID).Scan(&sub.CreatedAt)
+}
+
+func (d *db) GetSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	rows, err := d.QueryContext(ctx, `select id, url, events, created_at from webhook_subscription order by id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*webhook.Subscription, 0)
+	for rows.Next() {
+		sub := new(webhook.Subscription)
+		var types string
+		if err := rows.Scan(&sub.ID, &sub.Url, &types, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		sub.Events = splitEvents(types)
+		res = append(res, sub)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) DeleteSubscription(ctx context.Context, id uint32) error {
+	r, err := d.ExecContext(ctx, `delete from webhook_subscription where id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return apperror.ErrNotFound
+	}
+	d.logger.Infof("Deleted webhook subscription %d", id)
+	return nil
+}
+
+func (d *db) Lock(ctx context.Context) (func(), bool, error) {
+	return mysql.TryLock(ctx, d.DB, dispatchLock)
+}
+
+func (d *db) CreateDeliveries(ctx context.Context, messageId uint64, eventType events.Type, payload string) error {
+	_, err := d.ExecContext(ctx, `insert ignore into webhook_delivery (subscription_id, message_id, event_type, payload, status, next_attempt_at)
+		select id, ?, ?, ?, ?, now() from webhook_subscription where find_in_set(?, events) > 0;`,
+		messageId, eventType, payload, webhook.DeliveryPending, eventType)
+	return err
+}
+
+func (d *db) GetDueDeliveries(ctx context.Context, now time.Time, limit uint32) ([]*webhook.Delivery, error) {
+	rows, err := d.QueryContext(ctx, `select wd.id, wd.subscription_id, wd.event_type, wd.payload, wd.status, wd.attempts, wd.last_error, wd.next_attempt_at, wd.created_at, ws.url, ws.secret
+		from webhook_delivery wd join webhook_subscription ws on ws.id = wd.subscription_id
+		where wd.status = ? and wd.next_attempt_at <= ? order by wd.id limit ?;`, webhook.DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*webhook.Delivery, 0)
+	for rows.Next() {
+		item := new(webhook.Delivery)
+		if err := rows.Scan(&item.ID, &item.SubscriptionId, &item.EventType, &item.Payload, &item.Status, &item.Attempts, &item.LastError, &item.NextAttemptAt, &item.CreatedAt, &item.Url, &item.Secret); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) MarkDelivered(ctx context.Context, id uint32) error {
+	_, err := d.ExecContext(ctx, `update webhook_delivery set status = ?, attempts = attempts + 1, last_error = '' where id = ?;`, webhook.DeliveryDelivered, id)
+	return err
+}
+
+func (d *db) MarkFailed(ctx context.Context, item *webhook.Delivery) error {
+	_, err := d.ExecContext(ctx, `update webhook_delivery set status = ?, attempts = ?, last_error = ?, next_attempt_at = ? where id = ?;`,
+		item.Status, item.Attempts, item.LastError, item.NextAttemptAt, item.ID)
+	return err
+}
+
+func (d *db) GetDeadDeliveries(ctx context.Context) ([]*webhook.Delivery, error) {
+	rows, err := d.QueryContext(ctx, `select id, subscription_id, event_type, payload, status, attempts, last_error, next_attempt_at, created_at from webhook_delivery where status = ? order by id;`, webhook.DeliveryDead)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*webhook.Delivery, 0)
+	for rows.Next() {
+		item := new(webhook.Delivery)
+		if err := rows.Scan(&item.ID, &item.SubscriptionId, &item.EventType, &item.Payload, &item.Status, &item.Attempts, &item.LastError, &item.NextAttemptAt, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	return res, rows.Err()
+}
+
+func (d *db) Redeliver(ctx context.Context, id uint32) error {
+	r, err := d.ExecContext(ctx, `update webhook_delivery set status = ?, attempts = 0, next_attempt_at = now() where id = ? and status = ?;`, webhook.DeliveryPending, id, webhook.DeliveryDead)
+	if err != nil {
+		return err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return apperror.ErrNotFound
+	}
+	d.logger.Infof("Webhook delivery %d is queued for redelivery", id)
+	return nil
+}
+
+func NewStorage(database *sql.DB, logger *logging.Logger) webhook.Storage {
+	return &db{database, logger}
+}
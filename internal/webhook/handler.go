@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/handlers"
+	"user-balance-service/internal/middleware"
+	"user-balance-service/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type handler struct {
+	service *Service
+	logger  *logging.Logger
+}
+
+func NewHandler(service *Service, logger *logging.Logger) handlers.Handler {
+	return &handler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *handler) Register(router *handlers.Router) {
+	router.HandlerFunc(http.MethodPost, "/api/webhooks/", middleware.Middleware(h.CreateSubscription))
+	router.HandlerFunc(http.MethodGet, "/api/webhooks/", middleware.Middleware(h.GetSubscriptions))
+	router.HandlerFunc(http.MethodDelete, "/api/webhooks/:id", middleware.Middleware(h.DeleteSubscription))
+	router.HandlerFunc(http.MethodGet, "/api/webhooks/dead/", middleware.Middleware(h.GetDeadDeliveries))
+	router.HandlerFunc(http.MethodPost, "/api/webhooks/deliveries/:id/redeliver", middleware.Middleware(h.Redeliver))
+}
+
+func idParam(r *http.Request) (uint32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id <= 0 {
+		return 0, apperror.ErrBadRequest
+	}
+	return uint32(id), nil
+}
+
+func (h *handler) CreateSubscription(w http.ResponseWriter, r *http.Request) error {
+	var sub Subscription
+	err := json.NewDecoder(r.Body).Decode(&sub)
+	if err != nil {
+		return apperror.ErrBadRequest
+	}
+
+	err = h.service.CreateSubscription(context.Background(), &sub)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(sub)
+	return nil
+}
+
+func (h *handler) GetSubscriptions(w http.ResponseWriter, r *http.Request) error {
+	subs, err := h.service.GetSubscriptions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(subs)
+	return nil
+}
+
+func (h *handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	return h.service.DeleteSubscription(context.Background(), id)
+}
+
+func (h *handler) GetDeadDeliveries(w http.ResponseWriter, r *http.Request) error {
+	deliveries, err := h.service.GetDeadDeliveries(context.Background())
+	if err != nil {
+		return err
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+	return nil
+}
+
+func (h *handler) Redeliver(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	return h.service.Redeliver(context.Background(), id)
+}
@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"time"
+	"user-balance-service/internal/events"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead means all attempts failed, the delivery stays in the dead letter list until it is redelivered
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+type Subscription struct {
+	ID        uint32        `json:"id"`
+	Url       string        `json:"url"`
+	Events    []events.Type `json:"events"`
+	Secret    string        `json:"secret,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type Delivery struct {
+	ID             uint32         `json:"id"`
+	SubscriptionId uint32         `json:"subscription_id"`
+	EventType      events.Type    `json:"event_type"`
+	Payload        string         `json:"payload"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       uint32         `json:"attempts"`
+	LastError      string         `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+
+	Url    string `json:"-"`
+	Secret string `json:"-"`
+}
@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+	"user-balance-service/internal/apperror"
+	"user-balance-service/internal/outbox"
+	"user-balance-service/pkg/logging"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	deliveryBatchSize = 100
+	maxErrorLength    = 1024
+)
+
+type Options struct {
+	MaxAttempts  uint32
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+	// Concurrency is the number of deliveries sent at the same time, so a slow subscriber does not hold the others
+	Concurrency uint32
+}
+
+type Service struct {
+	storage Storage
+	options Options
+	client  *http.Client
+	logger  *logging.Logger
+}
+
+// Sign returns the signature of the payload sent at timestamp, receivers compute it
+// with the secret of their subscription and compare with the X-Webhook-Signature header
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay before the next attempt after attempts failed ones
+func Backoff(attempts uint32, base, max time.Duration) time.Duration {
+	delay := base
+	for i := uint32(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func (s *Service) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	u, err := url.Parse(sub.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return apperror.ErrBadRequest
+	}
+	if len(sub.Events) == 0 {
+		return apperror.ErrBadRequest
+	}
+	for _, t := range sub.Events {
+		if !t.Valid() {
+			return apperror.ErrBadRequest
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	sub.Secret = hex.EncodeToString(buf)
+	return s.storage.CreateSubscription(ctx, sub)
+}
+
+func (s *Service) GetSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	return s.storage.GetSubscriptions(ctx)
+}
+
+func (s *Service) DeleteSubscription(ctx context.Context, id uint32) error {
+	return s.storage.DeleteSubscription(ctx, id)
+}
+
+func (s *Service) GetDeadDeliveries(ctx context.Context) ([]*Delivery, error) {
+	return s.storage.GetDeadDeliveries(ctx)
+}
+
+func (s *Service) Redeliver(ctx context.Context, id uint32) error {
+	return s.storage.Redeliver(ctx, id)
+}
+
+// Publish queues deliveries of the outbox messages to the subscribers, so the service is fed
+// by the outbox relay and no event committed to the ledger is lost. A message published again
+// is not queued twice
+func (s *Service) Publish(ctx context.Context, msgs []*outbox.Message) error {
+	for _, m := range msgs {
+		if err := s.storage.CreateDeliveries(ctx, m.ID, m.Type, string(m.Payload)); err != nil {
+			s.logger.Errorf("Error %s in queueing webhooks for outbox message %d", err, m.ID)
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing, deliveries are kept in the storage
+func (s *Service) Close() error {
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, d *Delivery) error {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(d.Secret, timestamp, []byte(d.Payload)))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(EventHeader, string(d.EventType))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(d.ID), 10))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Subscriber responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Dispatch sends the deliveries which are due, failed ones are retried with exponential backoff
+// and moved to the dead letter list after the last attempt. Only one replica dispatches at a time
+func (s *Service) Dispatch(ctx context.Context) error {
+	unlock, ok, err := s.storage.Lock(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	defer unlock()
+
+	deliveries, err := s.storage.GetDueDeliveries(ctx, time.Now(), deliveryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	concurrency := s.options.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(d *Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.attempt(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Service) attempt(ctx context.Context, d *Delivery) {
+	err := s.deliver(ctx, d)
+	if err == nil {
+		if err := s.storage.MarkDelivered(ctx, d.ID); err != nil {
+			s.logger.Errorf("Error %s in marking webhook delivery %d", err, d.ID)
+		}
+		return
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if len(d.LastError) > maxErrorLength {
+		d.LastError = d.LastError[:maxErrorLength]
+	}
+	if d.Attempts >= s.options.MaxAttempts {
+		d.Status = DeliveryDead
+		s.logger.Errorf("Webhook delivery %d to %s is dead after %d attempts: %s", d.ID, d.Url, d.Attempts, err)
+	} else {
+		d.NextAttemptAt = time.Now().Add(Backoff(d.Attempts, s.options.BaseBackoff, s.options.MaxBackoff))
+	}
+	if err := s.storage.MarkFailed(ctx, d); err != nil {
+		s.logger.Errorf("Error %s in marking webhook delivery %d", err, d.ID)
+	}
+}
+
+// Run dispatches deliveries until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Dispatch(ctx); err != nil {
+				s.logger.Errorf("Error %s in dispatching webhooks", err)
+			}
+		}
+	}
+}
+
+func NewService(st Storage, options Options, logger *logging.Logger) *Service {
+	return &Service{
+		storage: st,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		logger:  logger,
+	}
+}
@@ -0,0 +1,24 @@
+package webhook
+
+import (
+	"context"
+	"time"
+	"user-balance-service/internal/events"
+)
+
+type Storage interface {
+	// Lock makes sure only one replica dispatches deliveries at a time
+	Lock(ctx context.Context) (unlock func(), ok bool, err error)
+	CreateSubscription(ctx context.Context, sub *Subscription) error
+	GetSubscriptions(ctx context.Context) ([]*Subscription, error)
+	DeleteSubscription(ctx context.Context, id uint32) error
+	// CreateDeliveries queues the payload of the outbox message for every subscription to the event type,
+	// deliveries of a message already queued are skipped
+	CreateDeliveries(ctx context.Context, messageId uint64, eventType events.Type, payload string) error
+	// GetDueDeliveries returns pending deliveries with url and secret of their subscriptions
+	GetDueDeliveries(ctx context.Context, now time.Time, limit uint32) ([]*Delivery, error)
+	MarkDelivered(ctx context.Context, id uint32) error
+	MarkFailed(ctx context.Context, d *Delivery) error
+	GetDeadDeliveries(ctx context.Context) ([]*Delivery, error)
+	Redeliver(ctx context.Context, id uint32) error
+}
@@ -31,3 +31,28 @@ func NewClient(ctx context.Context, host, port, username, password, database str
 
 	return db, nil
 }
+
+// TryLock takes the named lock which is shared by all the replicas of the service.
+// If the lock is held by someone else ok is false, otherwise unlock must be called to release it
+func TryLock(ctx context.Context, db *sql.DB, name string) (unlock func(), ok bool, err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `select get_lock(?, 0);`, name).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock = func() {
+		conn.ExecContext(context.Background(), `select release_lock(?);`, name)
+		conn.Close()
+	}
+	return unlock, true, nil
+}
@@ -0,0 +1,18 @@
+// Package filestore keeps generated files outside of the database
+package filestore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotExist is returned by Get when there is no file with the key
+var ErrNotExist = errors.New("File does not exist")
+
+// Store saves files by keys like "statements/1/2022-10.pdf"
+type Store interface {
+	// Put reads r until EOF, the file becomes visible only after it is completely written
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
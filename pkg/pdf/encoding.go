@@ -0,0 +1,40 @@
+package pdf
+
+import "strings"
+
+// cyrillic is transliterated because the standard fonts only cover the Latin alphabet
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// encode converts the text to WinAnsiEncoding, characters which can not be shown are replaced with "?"
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80 || r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		case cyrillic[r] != "" || r == 'ъ' || r == 'ь':
+			b.WriteString(cyrillic[r])
+		case cyrillic[toLower(r)] != "":
+			latin := cyrillic[toLower(r)]
+			b.WriteString(strings.ToUpper(latin[:1]) + latin[1:])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func toLower(r rune) rune {
+	switch {
+	case r >= 'А' && r <= 'Я':
+		return r + ('а' - 'А')
+	case r == 'Ё':
+		return 'ё'
+	}
+	return r
+}
@@ -0,0 +1,158 @@
+// Package pdf writes plain text documents page by page, only the current page is kept in memory.
+// The text is set in the standard Courier font, so the columns can be aligned with spaces
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const ContentType = "application/pdf"
+
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 40
+	fontSize   = 8
+	lineHeight = 11
+
+	// LineWidth is the number of characters which fit in a line
+	LineWidth = (pageWidth - 2*margin) * 10 / (fontSize * 6)
+
+	linesPerPage = (pageHeight - 2*margin) / lineHeight
+)
+
+const (
+	catalogId = 1
+	pagesId   = 2
+	fontId    = 3
+	firstId   = 4
+)
+
+// countingWriter remembers the offset of every object for the cross-reference table
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type Writer struct {
+	out     *countingWriter
+	offsets map[int]int64
+	nextId  int
+	pages   []int
+	page    *bytes.Buffer
+	lines   int
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{
+		out:     &countingWriter{w: w},
+		offsets: make(map[int]int64),
+		nextId:  firstId,
+	}
+	if _, err := io.WriteString(pw.out, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"); err != nil {
+		return nil, err
+	}
+	if err := pw.object(catalogId, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesId)); err != nil {
+		return nil, err
+	}
+	if err := pw.object(fontId, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>"); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (w *Writer) object(id int, body string) error {
+	w.offsets[id] = w.out.n
+	_, err := fmt.Fprintf(w.out, "%d 0 obj\n%s\nendobj\n", id, body)
+	return err
+}
+
+// Line adds a line of text, a new page is started when the current one is full
+func (w *Writer) Line(text string) error {
+	if w.page != nil && w.lines == linesPerPage {
+		if err := w.flushPage(); err != nil {
+			return err
+		}
+	}
+	if w.page == nil {
+		w.page = new(bytes.Buffer)
+		fmt.Fprintf(w.page, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, lineHeight, margin, pageHeight-margin-fontSize)
+		w.lines = 0
+	}
+
+	fmt.Fprintf(w.page, "(%s) '\n", escape(encode(text)))
+	w.lines++
+	return nil
+}
+
+func (w *Writer) flushPage() error {
+	w.page.WriteString("ET\n")
+	contentId, pageId := w.nextId, w.nextId+1
+	w.nextId += 2
+
+	w.offsets[contentId] = w.out.n
+	if _, err := fmt.Fprintf(w.out, "%d 0 obj\n<< /Length %d >>\nstream\n", contentId, w.page.Len()); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(w.page.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w.out, "\nendstream\nendobj\n"); err != nil {
+		return err
+	}
+
+	page := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+		pagesId, pageWidth, pageHeight, fontId, contentId)
+	if err := w.object(pageId, page); err != nil {
+		return err
+	}
+	w.pages = append(w.pages, pageId)
+	w.page = nil
+	return nil
+}
+
+// Close writes the page tree and the cross-reference table, it does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.page == nil && len(w.pages) == 0 {
+		if err := w.Line(""); err != nil {
+			return err
+		}
+	}
+	if w.page != nil {
+		if err := w.flushPage(); err != nil {
+			return err
+		}
+	}
+
+	kids := make([]string, len(w.pages))
+	for i, id := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	if err := w.object(pagesId, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages))); err != nil {
+		return err
+	}
+
+	xref := w.out.n
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", w.nextId)
+	for id := 1; id < w.nextId; id++ {
+		fmt.Fprintf(&b, "%010d 00000 n \n", w.offsets[id])
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", w.nextId, catalogId, xref)
+	_, err := w.out.Write(b.Bytes())
+	return err
+}
+
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+	return r.Replace(s)
+}